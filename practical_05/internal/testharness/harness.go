@@ -0,0 +1,294 @@
+// Package testharness starts Postgres and Redis containers once per test
+// binary and hands every test its own database and Redis logical database,
+// so tests can run with t.Parallel() without seeing each other's rows.
+//
+// A package declares one harness and closes it from TestMain:
+//
+//	var harness = testharness.New(testharness.Options{Seed: "../migrations/testdata/seed.sql"})
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		harness.Close()
+//		os.Exit(code)
+//	}
+//
+// Containers start on the first call to DB, EmptyDB or Redis, so tests that
+// need neither still run without Docker, and tests that do are skipped
+// when no container runtime is available.
+package testharness
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"practical5-example/migrations"
+
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	redisTC "github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// templateName is the migrated database every test database is cloned from
+const templateName = "users_template"
+
+// DefaultRedisDatabases is the number of Redis logical databases, and so
+// the number of tests that can hold a Redis client at once
+const DefaultRedisDatabases = 64
+
+// Options configures a Harness
+type Options struct {
+	// Seed is a SQL file loaded into the template after migrating
+	Seed string
+	// RedisDatabases overrides DefaultRedisDatabases
+	RedisDatabases int
+}
+
+// Harness owns the shared containers
+type Harness struct {
+	opts Options
+
+	once  sync.Once
+	err   error
+	pg    *postgres.PostgresContainer
+	redis *redisTC.RedisContainer
+
+	// admin is connected to the container's default database and runs
+	// CREATE/DROP DATABASE
+	admin     *sql.DB
+	pgURL     *url.URL
+	redisAddr string
+	redisDBs  chan int
+
+	// Cloning fails if another session is using the template, so clones
+	// are made one at a time
+	cloneMu sync.Mutex
+	seq     atomic.Int64
+}
+
+// New returns a harness; nothing is started until a test asks for it
+func New(opts Options) *Harness {
+	if opts.RedisDatabases <= 0 {
+		opts.RedisDatabases = DefaultRedisDatabases
+	}
+	return &Harness{opts: opts}
+}
+
+// require starts the containers on first use and skips t when there is
+// no container runtime
+func (h *Harness) require(t *testing.T) {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	h.once.Do(func() { h.err = h.start(context.Background()) })
+	if h.err != nil {
+		t.Fatalf("Failed to start test containers: %v", h.err)
+	}
+}
+
+func (h *Harness) start(ctx context.Context) error {
+	var err error
+	h.pg, err = postgres.RunContainer(ctx,
+		testcontainers.WithImage("postgres:15-alpine"),
+		postgres.WithDatabase("testdb"),
+		postgres.WithUsername("testuser"),
+		postgres.WithPassword("testpass"),
+		// Parallel tests each hold a pool of connections
+		testcontainers.WithCmdArgs("-c", "max_connections=500"),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).
+				WithStartupTimeout(30*time.Second)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start postgres: %w", err)
+	}
+
+	h.redis, err = redisTC.RunContainer(ctx,
+		testcontainers.WithImage("redis:7-alpine"),
+		testcontainers.WithCmd("redis-server", "--databases", strconv.Itoa(h.opts.RedisDatabases)),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("Ready to accept connections").
+				WithStartupTimeout(30*time.Second)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start redis: %w", err)
+	}
+
+	connStr, err := h.pg.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return fmt.Errorf("failed to get postgres connection: %w", err)
+	}
+	if h.pgURL, err = url.Parse(connStr); err != nil {
+		return fmt.Errorf("failed to parse postgres connection: %w", err)
+	}
+
+	h.admin, err = sql.Open("postgres", connStr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres: %w", err)
+	}
+
+	if err := h.buildTemplate(ctx); err != nil {
+		return err
+	}
+
+	redisURL, err := h.redis.ConnectionString(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get redis connection: %w", err)
+	}
+	redisOpts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse redis connection: %w", err)
+	}
+	h.redisAddr = redisOpts.Addr
+
+	h.redisDBs = make(chan int, h.opts.RedisDatabases)
+	for i := 0; i < h.opts.RedisDatabases; i++ {
+		h.redisDBs <- i
+	}
+
+	return nil
+}
+
+// buildTemplate migrates and seeds the template database
+func (h *Harness) buildTemplate(ctx context.Context) error {
+	if _, err := h.admin.ExecContext(ctx, "CREATE DATABASE "+templateName); err != nil {
+		return fmt.Errorf("failed to create template: %w", err)
+	}
+
+	db, err := sql.Open("postgres", h.dsn(templateName))
+	if err != nil {
+		return fmt.Errorf("failed to connect to template: %w", err)
+	}
+	// The template must have no open connections when it is cloned
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if _, err := migrator.Up(ctx, migrations.Options{}); err != nil {
+		return fmt.Errorf("failed to migrate template: %w", err)
+	}
+
+	if h.opts.Seed == "" {
+		return nil
+	}
+
+	seed, err := os.ReadFile(h.opts.Seed)
+	if err != nil {
+		return fmt.Errorf("failed to read seed data: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, string(seed)); err != nil {
+		return fmt.Errorf("failed to seed template: %w", err)
+	}
+
+	return nil
+}
+
+// dsn returns the connection string for database name
+func (h *Harness) dsn(name string) string {
+	u := *h.pgURL
+	u.Path = "/" + name
+	return u.String()
+}
+
+// DB returns a private database cloned from the migrated, seeded template.
+// It is dropped when t finishes.
+func (h *Harness) DB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, _ := h.DBWithDSN(t)
+	return db
+}
+
+// DBWithDSN is DB, also returning the database's connection string for
+// code that opens its own connections, such as a pq.Listener
+func (h *Harness) DBWithDSN(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	h.require(t)
+	return h.createDB(t, templateName)
+}
+
+// EmptyDB returns a private database with no schema, for testing
+// migrations themselves. It is dropped when t finishes.
+func (h *Harness) EmptyDB(t *testing.T) *sql.DB {
+	t.Helper()
+	h.require(t)
+	db, _ := h.createDB(t, "template0")
+	return db
+}
+
+func (h *Harness) createDB(t *testing.T, template string) (*sql.DB, string) {
+	t.Helper()
+	ctx := context.Background()
+	name := fmt.Sprintf("test_%d", h.seq.Add(1))
+
+	h.cloneMu.Lock()
+	_, err := h.admin.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", name, template))
+	h.cloneMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to create database %s: %v", name, err)
+	}
+
+	dsn := h.dsn(name)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to database %s: %v", name, err)
+	}
+
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := h.admin.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name)); err != nil {
+			t.Errorf("Failed to drop database %s: %v", name, err)
+		}
+	})
+
+	return db, dsn
+}
+
+// Redis returns a client bound to a Redis logical database no other test
+// is using. The database is flushed before use and again when t finishes,
+// then handed to the next test. If every database is taken, Redis waits.
+func (h *Harness) Redis(t *testing.T) *redis.Client {
+	t.Helper()
+	h.require(t)
+
+	index := <-h.redisDBs
+	client := redis.NewClient(&redis.Options{Addr: h.redisAddr, DB: index})
+	if err := client.FlushDB(context.Background()).Err(); err != nil {
+		h.redisDBs <- index
+		t.Fatalf("Failed to flush redis database %d: %v", index, err)
+	}
+
+	t.Cleanup(func() {
+		client.FlushDB(context.Background())
+		client.Close()
+		h.redisDBs <- index
+	})
+
+	return client
+}
+
+// Close terminates the containers if they were started
+func (h *Harness) Close() {
+	ctx := context.Background()
+	if h.admin != nil {
+		h.admin.Close()
+	}
+	if h.pg != nil {
+		h.pg.Terminate(ctx)
+	}
+	if h.redis != nil {
+		h.redis.Terminate(ctx)
+	}
+}
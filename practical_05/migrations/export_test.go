@@ -0,0 +1,4 @@
+package migrations
+
+// Embedded exposes the embedded migration files to external tests
+var Embedded = embedded
@@ -0,0 +1,312 @@
+// Package migrations applies the versioned schema in sql/ to a Postgres
+// database. Each migration is a pair of files named
+// NNNN_description.up.sql and NNNN_description.down.sql; applied versions
+// are recorded in the schema_migrations table.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sql/*.sql
+var embedded embed.FS
+
+// LockKey is the advisory lock held while migrating, so concurrent app
+// instances starting together apply each migration exactly once
+const LockKey int64 = 0x7573657273 // "users"
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a migration has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Options controls Up and Down
+type Options struct {
+	// DryRun reports which migrations would run without executing them
+	DryRun bool
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for the embedded migrations
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, migrations), nil
+}
+
+// NewMigrator returns a Migrator for an explicit migration list
+func NewMigrator(db *sql.DB, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{db: db, migrations: sorted}
+}
+
+// Load reads every *.up.sql/*.down.sql pair in fsys (searching
+// subdirectories) and returns them ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		match := fileName.FindStringSubmatch(path.Base(p))
+		if match == nil {
+			return nil
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration version in %s: %w", p, err)
+		}
+
+		body, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Status lists every known migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		statuses[i] = Status{Migration: mig, Applied: ok, AppliedAt: at}
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration in version order and returns the
+// ones it applied (or, with DryRun, would apply). Each migration runs in
+// its own transaction.
+func (m *Migrator) Up(ctx context.Context, opts Options) ([]Migration, error) {
+	return m.run(ctx, opts, func(applied map[int64]time.Time) []Migration {
+		var pending []Migration
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; !ok {
+				pending = append(pending, mig)
+			}
+		}
+		return pending
+	}, true)
+}
+
+// Down reverts the most recent steps applied migrations, newest first, and
+// returns the ones it reverted (or, with DryRun, would revert)
+func (m *Migrator) Down(ctx context.Context, steps int, opts Options) ([]Migration, error) {
+	return m.run(ctx, opts, func(applied map[int64]time.Time) []Migration {
+		var plan []Migration
+		for i := len(m.migrations) - 1; i >= 0 && len(plan) < steps; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		return plan
+	}, false)
+}
+
+// run plans and executes migrations while holding the advisory lock
+func (m *Migrator) run(ctx context.Context, opts Options, plan func(map[int64]time.Time) []Migration, up bool) ([]Migration, error) {
+	// Session-level advisory locks belong to a connection, so everything
+	// happens on one dedicated connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if !opts.DryRun {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", LockKey); err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", LockKey)
+
+		if err := ensureTable(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the state only after taking the lock, so a migration applied by
+	// another instance while we waited is not applied twice
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := plan(applied)
+	if opts.DryRun {
+		return migrations, nil
+	}
+
+	for i, mig := range migrations {
+		if err := apply(ctx, conn, mig, up); err != nil {
+			return migrations[:i], err
+		}
+	}
+
+	return migrations, nil
+}
+
+// apply runs one migration in either direction and records the result
+func apply(ctx context.Context, conn *sql.Conn, mig Migration, up bool) (err error) {
+	body := mig.Up
+	record := "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+	args := []interface{}{mig.Version, mig.Name}
+	if !up {
+		if mig.Down == "" {
+			return fmt.Errorf("migration %d_%s has no down file", mig.Version, mig.Name)
+		}
+		body = mig.Down
+		record = "DELETE FROM schema_migrations WHERE version = $1"
+		args = []interface{}{mig.Version}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Without arguments the simple query protocol is used, which allows
+	// several statements per file
+	if _, err = tx.ExecContext(ctx, body); err != nil {
+		return fmt.Errorf("migration %d_%s failed: %w", mig.Version, mig.Name, err)
+	}
+
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", mig.Version, err)
+	}
+
+	return nil
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// appliedVersions returns the applied versions and when they were applied.
+// A database that was never migrated has no table and nothing applied.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check schema_migrations: %w", err)
+	}
+
+	applied := make(map[int64]time.Time)
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan migration: %w", err)
+		}
+		applied[version] = at
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating migrations: %w", err)
+	}
+
+	return applied, nil
+}
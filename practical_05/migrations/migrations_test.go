@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("Embedded Migrations Are Complete", func(t *testing.T) {
+		migrations, err := Load(embedded)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		if len(migrations) == 0 {
+			t.Fatal("Expected embedded migrations")
+		}
+
+		for i, m := range migrations {
+			if m.Version != int64(i+1) {
+				t.Errorf("Expected version %d at position %d, got %d", i+1, i, m.Version)
+			}
+			if m.Up == "" || m.Down == "" {
+				t.Errorf("Migration %d_%s is missing an up or down file", m.Version, m.Name)
+			}
+		}
+	})
+
+	t.Run("Orders By Version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0010_later.up.sql":   {Data: []byte("SELECT 10")},
+			"0002_early.up.sql":   {Data: []byte("SELECT 2")},
+			"0002_early.down.sql": {Data: []byte("SELECT -2")},
+			"README.md":           {Data: []byte("ignored")},
+		}
+
+		migrations, err := Load(fsys)
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+
+		if len(migrations) != 2 {
+			t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+		}
+		if migrations[0].Version != 2 || migrations[1].Version != 10 {
+			t.Errorf("Expected versions [2 10], got [%d %d]", migrations[0].Version, migrations[1].Version)
+		}
+		if migrations[0].Down != "SELECT -2" {
+			t.Errorf("Expected down file to be loaded, got %q", migrations[0].Down)
+		}
+	})
+
+	t.Run("Missing Up File", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_only_down.down.sql": {Data: []byte("SELECT 1")},
+		}
+
+		if _, err := Load(fsys); err == nil {
+			t.Error("Expected error for a migration without an up file")
+		}
+	})
+
+	t.Run("Conflicting Names", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_first.up.sql":    {Data: []byte("SELECT 1")},
+			"0001_second.down.sql": {Data: []byte("SELECT 1")},
+		}
+
+		if _, err := Load(fsys); err == nil {
+			t.Error("Expected error for one version with two names")
+		}
+	})
+}
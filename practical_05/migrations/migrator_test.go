@@ -0,0 +1,140 @@
+package migrations_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"practical5-example/internal/testharness"
+	"practical5-example/migrations"
+)
+
+var harness = testharness.New(testharness.Options{})
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	harness.Close()
+	os.Exit(code)
+}
+
+func TestMigrator(t *testing.T) {
+	db := harness.EmptyDB(t)
+	ctx := context.Background()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	embedded, err := migrations.Load(migrations.Embedded)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	total := len(embedded)
+
+	t.Run("Dry Run Changes Nothing", func(t *testing.T) {
+		pending, err := migrator.Up(ctx, migrations.Options{DryRun: true})
+		if err != nil {
+			t.Fatalf("Dry run failed: %v", err)
+		}
+
+		if len(pending) != total {
+			t.Errorf("Expected %d pending migrations, got %d", total, len(pending))
+		}
+
+		var exists bool
+		db.QueryRowContext(ctx, "SELECT to_regclass('users') IS NOT NULL").Scan(&exists)
+		if exists {
+			t.Error("Expected dry run not to create the users table")
+		}
+	})
+
+	t.Run("Up Applies Every Migration", func(t *testing.T) {
+		applied, err := migrator.Up(ctx, migrations.Options{})
+		if err != nil {
+			t.Fatalf("Up failed: %v", err)
+		}
+
+		if len(applied) != total {
+			t.Errorf("Expected %d applied migrations, got %d", total, len(applied))
+		}
+
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+
+		for _, s := range statuses {
+			if !s.Applied || s.AppliedAt.IsZero() {
+				t.Errorf("Expected migration %d to be applied", s.Version)
+			}
+		}
+	})
+
+	t.Run("Up Is Idempotent", func(t *testing.T) {
+		applied, err := migrator.Up(ctx, migrations.Options{})
+		if err != nil {
+			t.Fatalf("Up failed: %v", err)
+		}
+
+		if len(applied) != 0 {
+			t.Errorf("Expected nothing to apply, got %d migrations", len(applied))
+		}
+	})
+
+	t.Run("Down Reverts Newest First", func(t *testing.T) {
+		reverted, err := migrator.Down(ctx, 2, migrations.Options{})
+		if err != nil {
+			t.Fatalf("Down failed: %v", err)
+		}
+
+		if len(reverted) != 2 {
+			t.Fatalf("Expected 2 reverted migrations, got %d", len(reverted))
+		}
+		if reverted[0].Version != int64(total) || reverted[1].Version != int64(total-1) {
+			t.Errorf("Expected versions [%d %d], got [%d %d]", total, total-1, reverted[0].Version, reverted[1].Version)
+		}
+
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+
+		for _, s := range statuses {
+			want := s.Version <= int64(total-2)
+			if s.Applied != want {
+				t.Errorf("Expected migration %d applied=%v, got %v", s.Version, want, s.Applied)
+			}
+		}
+	})
+
+	t.Run("Concurrent Up Applies Each Migration Once", func(t *testing.T) {
+		const instances = 5
+
+		var wg sync.WaitGroup
+		counts := make([]int, instances)
+		errs := make([]error, instances)
+
+		for i := 0; i < instances; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				applied, err := migrator.Up(ctx, migrations.Options{})
+				counts[i], errs[i] = len(applied), err
+			}(i)
+		}
+		wg.Wait()
+
+		sum := 0
+		for i := range counts {
+			if errs[i] != nil {
+				t.Errorf("Instance %d failed: %v", i, errs[i])
+			}
+			sum += counts[i]
+		}
+
+		if sum != 2 {
+			t.Errorf("Expected the 2 reverted migrations to be applied once in total, got %d", sum)
+		}
+	})
+}
@@ -0,0 +1,3 @@
+-- Optimistic concurrency: every write bumps version, and UpdateIfVersion
+-- only succeeds against the version the caller last read.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
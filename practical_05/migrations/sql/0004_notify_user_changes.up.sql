@@ -0,0 +1,32 @@
+-- Cache invalidation: every committed change to users is announced on the
+-- user_changes channel, whoever made it, so caches can evict the user.
+-- Payloads are JSON: {"op", "id", "old_email", "new_email"}, with the
+-- email a row did not have before or after the change left null.
+CREATE OR REPLACE FUNCTION notify_user_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'TRUNCATE' THEN
+        PERFORM pg_notify('user_changes', json_build_object('op', TG_OP)::text);
+    ELSIF TG_OP = 'INSERT' THEN
+        PERFORM pg_notify('user_changes', json_build_object(
+            'op', TG_OP, 'id', NEW.id, 'old_email', NULL, 'new_email', NEW.email)::text);
+    ELSIF TG_OP = 'UPDATE' THEN
+        PERFORM pg_notify('user_changes', json_build_object(
+            'op', TG_OP, 'id', NEW.id, 'old_email', OLD.email, 'new_email', NEW.email)::text);
+    ELSE
+        PERFORM pg_notify('user_changes', json_build_object(
+            'op', TG_OP, 'id', OLD.id, 'old_email', OLD.email, 'new_email', NULL)::text);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_notify_change ON users;
+CREATE TRIGGER users_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_change();
+
+-- TRUNCATE has no rows to report, so listeners flush everything
+DROP TRIGGER IF EXISTS users_notify_truncate ON users;
+CREATE TRIGGER users_notify_truncate
+    AFTER TRUNCATE ON users
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_user_change();
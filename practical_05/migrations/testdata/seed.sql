@@ -0,0 +1,4 @@
+-- Fixture rows the repository tests rely on (ids 1 and 2).
+INSERT INTO users (email, name) VALUES
+    ('alice@example.com', 'Alice Smith'),
+    ('bob@example.com', 'Bob Johnson');
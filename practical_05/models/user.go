@@ -8,4 +8,8 @@ type User struct {
 	Email     string    `json:"email"`
 	Name      string    `json:"name"`
 	CreatedAt time.Time `json:"created_at"`
+	// Version is bumped on every write and guards optimistic updates
+	Version int `json:"version"`
+	// DeletedAt is set while the user is soft-deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
@@ -0,0 +1,380 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Circuit breaker defaults for new cached repositories
+const (
+	// DefaultBreakerThreshold is how many Redis failures in a row open the
+	// circuit
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerCooldown is how long the circuit stays open before
+	// Redis is probed again
+	DefaultBreakerCooldown = 5 * time.Second
+	// DefaultMaxPendingInvalidations bounds the replay queue. Past it the
+	// queue gives up on individual keys and the whole cache namespace is
+	// flushed on recovery instead.
+	DefaultMaxPendingInvalidations = 10000
+)
+
+// errCacheOpen is returned for Redis calls skipped by an open circuit
+var errCacheOpen = errors.New("cache unavailable: circuit open")
+
+// CacheState says whether a cached repository is using Redis
+type CacheState int
+
+const (
+	// CacheHealthy means Redis calls go through
+	CacheHealthy CacheState = iota
+	// CacheDegraded means Redis kept failing and is being skipped: reads
+	// go straight to the store and invalidations are queued
+	CacheDegraded
+	// CacheRecovering means Redis is being probed and queued invalidations
+	// replayed; Redis is still skipped until that succeeds
+	CacheRecovering
+)
+
+func (s CacheState) String() string {
+	switch s {
+	case CacheHealthy:
+		return "healthy"
+	case CacheDegraded:
+		return "degraded"
+	case CacheRecovering:
+		return "recovering"
+	default:
+		return "unknown"
+	}
+}
+
+// CacheHealth is a snapshot of the cache's circuit breaker
+type CacheHealth struct {
+	State CacheState
+	// Since is when the current state was entered
+	Since time.Time
+	// ConsecutiveFailures counts Redis errors since the last success
+	ConsecutiveFailures int
+	// LastError is the most recent Redis error, if any
+	LastError error
+	// PendingInvalidations counts keys waiting to be deleted once Redis
+	// recovers
+	PendingInvalidations int
+}
+
+// breaker opens after threshold consecutive failures and lets a single
+// probe through once cooldown has passed. A threshold of zero disables it.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     CacheState
+	since     time.Time
+	failures  int
+	lastErr   error
+}
+
+// acquire reports whether a Redis call may go ahead, or whether the caller
+// has been chosen to probe Redis before anyone else may use it
+func (b *breaker) acquire() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.threshold <= 0 || b.state == CacheHealthy:
+		return true, false
+	case b.state == CacheDegraded && time.Since(b.since) >= b.cooldown:
+		b.enter(CacheRecovering)
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// record counts the outcome of a call made while healthy. Misses and
+// cancelled requests say nothing about Redis, so they are ignored.
+func (b *breaker) record(err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != CacheHealthy {
+		return
+	}
+	if err == nil || err == redis.Nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.enter(CacheDegraded)
+	}
+}
+
+// settle ends a probe, closing the circuit on success and reopening it for
+// another cooldown on failure
+func (b *breaker) settle(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.lastErr = err
+		b.enter(CacheDegraded)
+		return
+	}
+	b.failures = 0
+	b.enter(CacheHealthy)
+}
+
+func (b *breaker) enter(state CacheState) {
+	b.state = state
+	b.since = time.Now()
+}
+
+// pendingInvalidations holds cache keys whose deletion failed, and the ids
+// to announce to L1 caches once they are deleted
+type pendingInvalidations struct {
+	mu       sync.Mutex
+	limit    int
+	keys     map[string]struct{}
+	ids      map[int]struct{}
+	overflow bool
+}
+
+func newPendingInvalidations(limit int) *pendingInvalidations {
+	return &pendingInvalidations{
+		limit: limit,
+		keys:  make(map[string]struct{}),
+		ids:   make(map[int]struct{}),
+	}
+}
+
+func (p *pendingInvalidations) add(keys []string, ids []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.overflow {
+		return
+	}
+	for _, key := range keys {
+		p.keys[key] = struct{}{}
+	}
+	for _, id := range ids {
+		p.ids[id] = struct{}{}
+	}
+
+	// Past the limit the namespace is flushed on recovery, so the
+	// individual keys are no longer needed
+	if len(p.keys) > p.limit {
+		p.overflow = true
+		clear(p.keys)
+		clear(p.ids)
+	}
+}
+
+// take empties the queue and returns what was in it
+func (p *pendingInvalidations) take() (keys []string, ids []int, overflow bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key := range p.keys {
+		keys = append(keys, key)
+	}
+	for id := range p.ids {
+		ids = append(ids, id)
+	}
+	overflow = p.overflow
+
+	clear(p.keys)
+	clear(p.ids)
+	p.overflow = false
+	return keys, ids, overflow
+}
+
+func (p *pendingInvalidations) restore(keys []string, ids []int, overflow bool) {
+	if overflow {
+		p.mu.Lock()
+		p.overflow = true
+		p.mu.Unlock()
+		return
+	}
+	p.add(keys, ids)
+}
+
+func (p *pendingInvalidations) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.overflow {
+		return p.limit + 1
+	}
+	return len(p.keys)
+}
+
+// WithCircuitBreaker sets how many consecutive Redis failures open the
+// circuit and how long it stays open before Redis is probed again. A
+// threshold of zero disables the breaker. Call it before the repository is
+// used.
+func (r *CachedUserRepository) WithCircuitBreaker(threshold int, cooldown time.Duration) *CachedUserRepository {
+	r.breaker.threshold = threshold
+	r.breaker.cooldown = cooldown
+	return r
+}
+
+// Health reports whether Redis is in use and how many invalidations are
+// waiting for it to come back
+func (r *CachedUserRepository) Health() CacheHealth {
+	r.breaker.mu.Lock()
+	defer r.breaker.mu.Unlock()
+
+	return CacheHealth{
+		State:                r.breaker.state,
+		Since:                r.breaker.since,
+		ConsecutiveFailures:  r.breaker.failures,
+		LastError:            r.breaker.lastErr,
+		PendingInvalidations: r.pending.len(),
+	}
+}
+
+// guard runs a Redis call through the circuit breaker. While the circuit
+// is open the call is skipped and errCacheOpen returned, so callers treat
+// Redis as a miss without waiting on the network.
+func (r *CachedUserRepository) guard(ctx context.Context, call func() error) error {
+	allowed, probe := r.breaker.acquire()
+	if probe {
+		allowed = r.probe(ctx) == nil
+	}
+	if !allowed {
+		r.stats.bypassed.Add(1)
+		return errCacheOpen
+	}
+
+	err := call()
+	r.breaker.record(err)
+	if (err == nil || err == redis.Nil) && r.pending.len() > 0 {
+		r.replaySoon()
+	}
+	return err
+}
+
+// probe checks Redis and replays queued invalidations before closing the
+// circuit, so no reader can see an entry whose invalidation is still
+// queued
+func (r *CachedUserRepository) probe(ctx context.Context) error {
+	ctx = context.WithoutCancel(ctx)
+
+	err := r.cache.Ping(ctx).Err()
+	if err == nil {
+		err = r.replay(ctx)
+	}
+	r.breaker.settle(err)
+	return err
+}
+
+// invalidate deletes keys and announces ids to L1 caches, queueing both
+// for replay if Redis cannot be reached
+func (r *CachedUserRepository) invalidate(ctx context.Context, keys []string, ids []int) {
+	if r.l1 != nil {
+		r.l1.remove(ids...)
+	}
+
+	err := r.guard(ctx, func() error {
+		_, err := r.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			r.announce(ctx, pipe, ids...)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		r.pending.add(keys, ids)
+	}
+}
+
+// replaySoon replays queued invalidations in the background, at most one
+// replay at a time
+func (r *CachedUserRepository) replaySoon() {
+	if !r.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer r.replaying.Store(false)
+		r.replay(context.Background())
+	}()
+}
+
+// replay deletes every queued key, or flushes the namespace if the queue
+// overflowed. Whatever fails goes back on the queue.
+func (r *CachedUserRepository) replay(ctx context.Context) error {
+	keys, ids, overflow := r.pending.take()
+
+	var err error
+	switch {
+	case overflow:
+		err = r.flush(ctx)
+	case len(keys) > 0:
+		_, err = r.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, keys...)
+			r.announce(ctx, pipe, ids...)
+			return nil
+		})
+	}
+
+	if err != nil {
+		r.pending.restore(keys, ids, overflow)
+	}
+	return err
+}
+
+// invalidateAll flushes the user cache namespace, queueing the flush for
+// replay if Redis is unavailable
+func (r *CachedUserRepository) invalidateAll(ctx context.Context) {
+	if r.l1 != nil {
+		r.l1.purge()
+	}
+
+	err := r.guard(ctx, func() error { return r.flush(ctx) })
+	if err != nil {
+		r.pending.restore(nil, nil, true)
+	}
+}
+
+// flush deletes every key in the user cache namespace and tells every L1
+// to purge
+func (r *CachedUserRepository) flush(ctx context.Context) error {
+	iter := r.cache.Scan(ctx, 0, r.keys.all(), 1000).Iterator()
+
+	batch := make([]string, 0, 1000)
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := r.cache.Del(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := r.cache.Del(ctx, batch...).Err(); err != nil {
+			return err
+		}
+	}
+
+	if r.l1 != nil {
+		r.l1.purge()
+	}
+	return r.cache.Publish(ctx, r.keys.channel(), flushAll).Err()
+}
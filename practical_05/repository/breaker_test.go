@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"net"
+	"practical5-example/repository/fixtures"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBreaker(t *testing.T) {
+	failure := errors.New("connection refused")
+
+	t.Run("Opens After Threshold", func(t *testing.T) {
+		b := &breaker{threshold: 3, cooldown: time.Hour}
+		b.record(failure)
+		b.record(failure)
+		b.record(nil)
+		b.record(failure)
+		b.record(failure)
+
+		if allowed, _ := b.acquire(); !allowed {
+			t.Fatal("Expected a success to reset the failure count")
+		}
+
+		b.record(failure)
+		if allowed, probe := b.acquire(); allowed || probe {
+			t.Errorf("Expected the circuit to be open, got allowed=%v probe=%v", allowed, probe)
+		}
+	})
+
+	t.Run("Misses And Cancellations Are Not Failures", func(t *testing.T) {
+		b := &breaker{threshold: 1, cooldown: time.Hour}
+		b.record(redis.Nil)
+		b.record(context.Canceled)
+
+		if b.state != CacheHealthy {
+			t.Errorf("Expected healthy, got: %v", b.state)
+		}
+	})
+
+	t.Run("One Probe After Cooldown", func(t *testing.T) {
+		b := &breaker{threshold: 1, cooldown: 10 * time.Millisecond}
+		b.record(failure)
+		time.Sleep(20 * time.Millisecond)
+
+		if _, probe := b.acquire(); !probe {
+			t.Fatal("Expected the first caller after cooldown to probe")
+		}
+		if allowed, probe := b.acquire(); allowed || probe {
+			t.Error("Expected other callers to skip Redis while probing")
+		}
+
+		b.settle(failure)
+		if b.state != CacheDegraded {
+			t.Errorf("Expected a failed probe to reopen the circuit, got: %v", b.state)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		b.acquire()
+		b.settle(nil)
+		if allowed, _ := b.acquire(); !allowed {
+			t.Error("Expected a successful probe to close the circuit")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		b := &breaker{}
+		for i := 0; i < 10; i++ {
+			b.record(failure)
+		}
+		if allowed, _ := b.acquire(); !allowed {
+			t.Error("Expected a zero threshold to never open")
+		}
+	})
+}
+
+func TestPendingInvalidations(t *testing.T) {
+	p := newPendingInvalidations(3)
+	p.add([]string{"user:1", "user:2"}, []int{1, 2})
+	p.add([]string{"user:1"}, []int{1})
+
+	if p.len() != 2 {
+		t.Errorf("Expected keys to be deduplicated, got %d", p.len())
+	}
+
+	p.add([]string{"user:3", "user:4"}, []int{3, 4})
+	keys, ids, overflow := p.take()
+	if !overflow || len(keys) != 0 || len(ids) != 0 {
+		t.Errorf("Expected overflow to replace the keys, got %v %v %v", keys, ids, overflow)
+	}
+
+	if p.len() != 0 {
+		t.Errorf("Expected take to empty the queue, got %d", p.len())
+	}
+}
+
+// flakyRedis returns a client for rdb's database whose connections fail
+// while down is set, without closing them
+func flakyRedis(t *testing.T, rdb *redis.Client, down *atomic.Bool) *redis.Client {
+	opts := rdb.Options()
+	client := redis.NewClient(&redis.Options{
+		Addr:       opts.Addr,
+		DB:         opts.DB,
+		MaxRetries: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if down.Load() {
+				return nil, errors.New("redis is down")
+			}
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			return &flakyConn{Conn: conn, down: down}, err
+		},
+	})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+type flakyConn struct {
+	net.Conn
+	down *atomic.Bool
+}
+
+func (c *flakyConn) Read(b []byte) (int, error) {
+	if c.down.Load() {
+		return 0, errors.New("redis is down")
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	if c.down.Load() {
+		return 0, errors.New("redis is down")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestCachedDegradation(t *testing.T) {
+	t.Parallel()
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+
+	var down atomic.Bool
+	store := NewMemoryUserStore()
+	repo := NewCachedUserStore(store, flakyRedis(t, rdb, &down)).WithCircuitBreaker(3, 50*time.Millisecond)
+
+	user, err := repo.CreateCached(ctx, fixtures.New(t, store).Email(), "Before Outage")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	down.Store(true)
+
+	t.Run("Failed Invalidation Is Queued", func(t *testing.T) {
+		if err := repo.UpdateCached(ctx, user.ID, user.Email, "During Outage"); err != nil {
+			t.Fatalf("Expected the update to succeed without Redis: %v", err)
+		}
+
+		if pending := repo.Health().PendingInvalidations; pending == 0 {
+			t.Error("Expected the failed invalidation to be queued")
+		}
+	})
+
+	t.Run("Reads Skip Redis While Open", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			found, err := repo.GetByIDCached(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Expected reads to fall back to the store: %v", err)
+			}
+			if found.Name != "During Outage" {
+				t.Errorf("Expected the stored name, got: %s", found.Name)
+			}
+		}
+
+		health := repo.Health()
+		if health.State != CacheDegraded || health.LastError == nil {
+			t.Errorf("Expected a degraded cache with an error, got: %+v", health)
+		}
+		if repo.Stats().Bypassed == 0 {
+			t.Error("Expected Redis calls to be bypassed while the circuit is open")
+		}
+	})
+
+	t.Run("Recovery Replays Invalidations", func(t *testing.T) {
+		down.Store(false)
+		time.Sleep(60 * time.Millisecond)
+
+		// Without the replay this would be the stale entry from before
+		// the outage
+		found, err := repo.GetByIDCached(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if found.Name != "During Outage" {
+			t.Errorf("Expected the stale entry to be gone, got: %s", found.Name)
+		}
+
+		health := repo.Health()
+		if health.State != CacheHealthy || health.PendingInvalidations != 0 {
+			t.Errorf("Expected a healthy cache with nothing queued, got: %+v", health)
+		}
+
+		if exists, _ := rdb.Exists(ctx, repo.keys.user(user.ID)).Result(); exists != 1 {
+			t.Error("Expected the user to be cached again")
+		}
+	})
+}
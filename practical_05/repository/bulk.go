@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+
+	"github.com/lib/pq"
+)
+
+// DefaultProgressInterval is how many rows BulkImport copies between
+// progress callbacks when BulkImportOptions.ProgressInterval is zero
+const DefaultProgressInterval = 10000
+
+// UserInput holds the fields needed to create a user
+type UserInput struct {
+	Email string
+	Name  string
+}
+
+// DuplicatePolicy decides what BulkImport does with an email that is
+// already taken, either by an existing user or earlier in the same input
+type DuplicatePolicy int
+
+const (
+	// DuplicateFail aborts the whole import with ErrDuplicateEmail
+	DuplicateFail DuplicatePolicy = iota
+	// DuplicateSkip keeps the existing user and drops the input row
+	DuplicateSkip
+	// DuplicateUpsert overwrites the existing user's name; within the
+	// input the last occurrence of an email wins
+	DuplicateUpsert
+)
+
+// BulkImportOptions configures BulkImport
+type BulkImportOptions struct {
+	OnDuplicate DuplicatePolicy
+	// Progress, if set, is called with the running total of copied rows
+	// every ProgressInterval rows and once more when copying finishes
+	Progress         func(copied int)
+	ProgressInterval int
+}
+
+// BulkImportResult summarises a finished import
+type BulkImportResult struct {
+	// Copied is the number of input rows streamed to the server
+	Copied int
+	// IDs holds the ids of the inserted or upserted users in input order
+	IDs []int
+	// Skipped counts input rows that did not produce a user
+	Skipped int
+}
+
+// BulkImport loads users with COPY into a temporary staging table and then
+// moves them into users with a single INSERT ... SELECT, which is orders of
+// magnitude faster than BatchCreate for large imports. The import is
+// atomic: on any error nothing is written. It joins the surrounding
+// transaction when the repository is bound to one.
+//
+// The input is consumed once; an error yielded by it aborts the import.
+func (r *UserRepository) BulkImport(ctx context.Context, users iter.Seq2[UserInput, error], opts BulkImportOptions) (BulkImportResult, error) {
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = DefaultProgressInterval
+	}
+
+	var conflict, dedupe string
+	switch opts.OnDuplicate {
+	case DuplicateFail:
+	case DuplicateSkip:
+		conflict = "ON CONFLICT (email) WHERE deleted_at IS NULL DO NOTHING"
+		dedupe = "DISTINCT ON (email)"
+	case DuplicateUpsert:
+		conflict = "ON CONFLICT (email) WHERE deleted_at IS NULL DO UPDATE SET name = EXCLUDED.name, version = users.version + 1"
+		dedupe = "DISTINCT ON (email)"
+	default:
+		return BulkImportResult{}, invalidInput("on_duplicate", opts.OnDuplicate, "unknown duplicate policy")
+	}
+
+	var result BulkImportResult
+	err := r.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		tx := txRepo.db.(*sql.Tx)
+		staging := fmt.Sprintf("users_import_%d", objectSeq.Add(1))
+
+		create := fmt.Sprintf("CREATE TEMP TABLE %s (seq INT, email VARCHAR(255), name VARCHAR(255)) ON COMMIT DROP", staging)
+		if _, err := tx.ExecContext(ctx, create); err != nil {
+			return fmt.Errorf("failed to create staging table: %w", classify(err))
+		}
+
+		copied, err := copyUsers(ctx, tx, staging, users, opts)
+		result.Copied = copied
+		if err != nil {
+			return err
+		}
+
+		// For DISTINCT ON, ordering by seq DESC keeps the last occurrence
+		order := "seq"
+		if opts.OnDuplicate == DuplicateUpsert {
+			order = "seq DESC"
+		}
+		if dedupe != "" {
+			order = "email, " + order
+		}
+
+		query := fmt.Sprintf(`
+			WITH src AS (
+				SELECT %s seq, email, name FROM %s ORDER BY %s
+			), ins AS (
+				INSERT INTO users (email, name)
+				SELECT email, name FROM src ORDER BY seq
+				%s
+				RETURNING id, email
+			)
+			SELECT ins.id FROM ins JOIN src ON src.email = ins.email ORDER BY src.seq
+		`, dedupe, staging, order, conflict)
+
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to import users: %w", classify(err))
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return fmt.Errorf("failed to scan id: %w", err)
+			}
+			result.IDs = append(result.IDs, id)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to import users: %w", classify(err))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return BulkImportResult{Copied: result.Copied}, err
+	}
+
+	result.Skipped = result.Copied - len(result.IDs)
+	return result, nil
+}
+
+// copyUsers streams users into the staging table with COPY FROM STDIN
+func copyUsers(ctx context.Context, tx *sql.Tx, staging string, users iter.Seq2[UserInput, error], opts BulkImportOptions) (int, error) {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(staging, "seq", "email", "name"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to start copy: %w", classify(err))
+	}
+	defer stmt.Close()
+
+	copied := 0
+	for user, err := range users {
+		if err != nil {
+			return copied, fmt.Errorf("failed to read input row %d: %w", copied+1, err)
+		}
+		if err := validateUser(user.Email, user.Name); err != nil {
+			return copied, err
+		}
+
+		if _, err := stmt.ExecContext(ctx, copied, user.Email, user.Name); err != nil {
+			return copied, fmt.Errorf("failed to copy user: %w", classify(err))
+		}
+		copied++
+
+		if opts.Progress != nil && copied%opts.ProgressInterval == 0 {
+			opts.Progress(copied)
+		}
+	}
+
+	// An Exec without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return copied, fmt.Errorf("failed to finish copy: %w", classify(err))
+	}
+	if opts.Progress != nil {
+		opts.Progress(copied)
+	}
+
+	return copied, nil
+}
+
+// stageImport reads users into memory for backends without COPY. It
+// validates and reports progress like the COPY path, and returns the rows
+// to apply, already deduplicated the way the policy dedupes the staging
+// table, in the order their ids are reported.
+func stageImport(users iter.Seq2[UserInput, error], opts BulkImportOptions) ([]UserInput, int, error) {
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = DefaultProgressInterval
+	}
+	if opts.OnDuplicate < DuplicateFail || opts.OnDuplicate > DuplicateUpsert {
+		return nil, 0, invalidInput("on_duplicate", opts.OnDuplicate, "unknown duplicate policy")
+	}
+
+	var rows []UserInput
+	seen := make(map[string]int)
+	copied := 0
+	for user, err := range users {
+		if err != nil {
+			return nil, copied, fmt.Errorf("failed to read input row %d: %w", copied+1, err)
+		}
+		if err := validateUser(user.Email, user.Name); err != nil {
+			return nil, copied, err
+		}
+		copied++
+
+		if opts.Progress != nil && copied%opts.ProgressInterval == 0 {
+			opts.Progress(copied)
+		}
+
+		i, dup := seen[user.Email]
+		switch {
+		case !dup || opts.OnDuplicate == DuplicateFail:
+			seen[user.Email] = len(rows)
+			rows = append(rows, user)
+		case opts.OnDuplicate == DuplicateUpsert:
+			// The last occurrence wins and takes over its position
+			rows[i] = UserInput{}
+			seen[user.Email] = len(rows)
+			rows = append(rows, user)
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(copied)
+	}
+
+	staged := rows[:0]
+	for _, row := range rows {
+		if row.Email != "" {
+			staged = append(staged, row)
+		}
+	}
+
+	return staged, copied, nil
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"testing"
+)
+
+// inputs adapts a slice to the iterator BulkImport consumes
+func inputs(users ...UserInput) func(yield func(UserInput, error) bool) {
+	return func(yield func(UserInput, error) bool) {
+		for _, u := range users {
+			if !yield(u, nil) {
+				return
+			}
+		}
+	}
+}
+
+func TestBulkImport(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	// forget deletes the users an import wrote
+	forget := func(ids []int) {
+		for _, id := range ids {
+			repo.Delete(ctx, id)
+		}
+	}
+
+	t.Run("Imports In Input Order", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		emails := make([]string, 1000)
+		for i := range emails {
+			emails[i] = f.Email()
+		}
+
+		generated := func(yield func(UserInput, error) bool) {
+			for i, email := range emails {
+				if !yield(UserInput{Email: email, Name: fmt.Sprintf("Bulk %d", i)}, nil) {
+					return
+				}
+			}
+		}
+
+		var progress []int
+		opts := BulkImportOptions{
+			Progress:         func(copied int) { progress = append(progress, copied) },
+			ProgressInterval: 400,
+		}
+
+		result, err := repo.BulkImport(ctx, generated, opts)
+		if err != nil {
+			t.Fatalf("Failed to import users: %v", err)
+		}
+		defer forget(result.IDs)
+
+		if result.Copied != 1000 || len(result.IDs) != 1000 || result.Skipped != 0 {
+			t.Fatalf("Unexpected result: copied=%d ids=%d skipped=%d", result.Copied, len(result.IDs), result.Skipped)
+		}
+
+		if fmt.Sprint(progress) != "[400 800 1000]" {
+			t.Errorf("Expected progress [400 800 1000], got: %v", progress)
+		}
+
+		user, err := repo.GetByID(ctx, result.IDs[42])
+		if err != nil {
+			t.Fatalf("Failed to get imported user: %v", err)
+		}
+
+		if user.Email != emails[42] {
+			t.Errorf("Expected IDs in input order, id %d is %s", result.IDs[42], user.Email)
+		}
+	})
+
+	t.Run("Duplicate Fails Whole Import", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{})
+		countBefore, _ := repo.CountUsers(ctx)
+
+		_, err := repo.BulkImport(ctx, inputs(
+			UserInput{f.Email(), "Bulk A"},
+			UserInput{existing.Email, "Duplicate"},
+		), BulkImportOptions{})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+
+		countAfter, _ := repo.CountUsers(ctx)
+		if countAfter != countBefore {
+			t.Error("Expected failed import to write nothing")
+		}
+	})
+
+	t.Run("Duplicate Skip", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{})
+		email := f.Email()
+
+		result, err := repo.BulkImport(ctx, inputs(
+			UserInput{email, "Bulk A"},
+			UserInput{existing.Email, "Duplicate"},
+			UserInput{email, "Bulk A Again"},
+		), BulkImportOptions{OnDuplicate: DuplicateSkip})
+		if err != nil {
+			t.Fatalf("Failed to import users: %v", err)
+		}
+		defer forget(result.IDs)
+
+		if len(result.IDs) != 1 || result.Skipped != 2 {
+			t.Errorf("Expected 1 inserted and 2 skipped, got: ids=%v skipped=%d", result.IDs, result.Skipped)
+		}
+
+		untouched, _ := repo.GetByEmail(ctx, existing.Email)
+		if untouched.Name != existing.Name {
+			t.Errorf("Expected existing user untouched, got: %s", untouched.Name)
+		}
+
+		first, _ := repo.GetByEmail(ctx, email)
+		if first.Name != "Bulk A" {
+			t.Errorf("Expected first occurrence to win, got: %s", first.Name)
+		}
+	})
+
+	t.Run("Duplicate Upsert", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{Name: "Old Name"})
+		email := f.Email()
+
+		result, err := repo.BulkImport(ctx, inputs(
+			UserInput{existing.Email, "New Name"},
+			UserInput{email, "Bulk B"},
+			UserInput{email, "Bulk B Last"},
+		), BulkImportOptions{OnDuplicate: DuplicateUpsert})
+		if err != nil {
+			t.Fatalf("Failed to import users: %v", err)
+		}
+		defer forget(result.IDs)
+
+		if len(result.IDs) != 2 || result.IDs[0] != existing.ID {
+			t.Errorf("Expected existing id first among 2 ids, got: %v", result.IDs)
+		}
+
+		updated, _ := repo.GetByID(ctx, existing.ID)
+		if updated.Name != "New Name" {
+			t.Errorf("Expected upserted name, got: %s", updated.Name)
+		}
+
+		last, _ := repo.GetByEmail(ctx, email)
+		if last.Name != "Bulk B Last" {
+			t.Errorf("Expected last occurrence to win, got: %s", last.Name)
+		}
+	})
+
+	t.Run("Input Error Aborts", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		errRead := errors.New("read failed")
+		failing := func(yield func(UserInput, error) bool) {
+			if yield(UserInput{email, "Bulk C"}, nil) {
+				yield(UserInput{}, errRead)
+			}
+		}
+
+		_, err := repo.BulkImport(ctx, failing, BulkImportOptions{})
+		if !errors.Is(err, errRead) {
+			t.Fatalf("Expected input error, got: %v", err)
+		}
+
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected nothing imported, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+// Cache policy defaults, used for any CacheOptions field left zero
+const (
+	// DefaultReadTTL is how long an entry filled by a cache miss lives
+	DefaultReadTTL = 5 * time.Minute
+	// DefaultWriteTTL is how long an entry written after a change lives
+	DefaultWriteTTL = 5 * time.Minute
+	// DefaultTTLJitter spreads expiry over up to 10% more than the TTL
+	DefaultTTLJitter = 0.1
+	// CacheSchemaVersion is the version of the cached user layout. Bump it
+	// whenever models.User, CacheEntry or the envelope change
+	// incompatibly. Version 2 wrapped entries in the codec envelope.
+	CacheSchemaVersion = 2
+)
+
+// CacheOptions sets the key layout and expiry policy of a cached
+// repository. Keys look like
+//
+//	<namespace>:user:v<version>:<id>
+//	<namespace>:user:v<version>:email:<normalized email>
+//	<namespace>:user:v<version>:missing:<id>
+//
+// with the namespace and its colon left out when it is empty.
+type CacheOptions struct {
+	// Namespace prefixes every key and the L1 invalidation channel, so
+	// tenants or tests can share one Redis database without seeing each
+	// other's entries
+	Namespace string
+	// ReadTTL applies to entries filled by reads, WriteTTL to entries
+	// written after Create, Update and other changes
+	ReadTTL  time.Duration
+	WriteTTL time.Duration
+	// Jitter adds a random extra of up to this fraction of the TTL to each
+	// entry, so entries cached together do not all expire together.
+	// Negative disables it.
+	Jitter float64
+	// SchemaVersion is baked into every key. Instances with different
+	// versions never read each other's entries, so a new layout can roll
+	// out without old payloads being decoded. Each version's entries are
+	// invalidated only by instances of that version, so during a rollout
+	// the TTLs bound how stale the other version's entries can get.
+	SchemaVersion int
+	// Codec encodes entries; JSONCodec if nil. Entries written by another
+	// codec are discarded, so change codecs together with SchemaVersion.
+	Codec Codec
+	// CompressAbove deflates encoded entries longer than this many bytes.
+	// Zero never compresses.
+	CompressAbove int
+}
+
+// withDefaults fills the fields left zero
+func (o CacheOptions) withDefaults() CacheOptions {
+	if o.ReadTTL <= 0 {
+		o.ReadTTL = DefaultReadTTL
+	}
+	if o.WriteTTL <= 0 {
+		o.WriteTTL = DefaultWriteTTL
+	}
+	if o.Jitter == 0 {
+		o.Jitter = DefaultTTLJitter
+	}
+	if o.SchemaVersion <= 0 {
+		o.SchemaVersion = CacheSchemaVersion
+	}
+	if o.Codec == nil {
+		o.Codec = JSONCodec{}
+	}
+	return o
+}
+
+// ttl returns base stretched by a random share of the jitter
+func (o CacheOptions) ttl(base time.Duration) time.Duration {
+	if o.Jitter <= 0 {
+		return base
+	}
+	return base + rand.N(time.Duration(float64(base)*o.Jitter)+1)
+}
+
+// keyspace builds the Redis keys and channel of one cached repository
+type keyspace struct {
+	// namespace is the configured namespace plus its colon, if any
+	namespace string
+	// prefix is what every user key starts with, up to the version
+	prefix string
+}
+
+func newKeyspace(opts CacheOptions) keyspace {
+	var ns string
+	if opts.Namespace != "" {
+		ns = opts.Namespace + ":"
+	}
+	return keyspace{namespace: ns, prefix: ns + "user:v" + strconv.Itoa(opts.SchemaVersion) + ":"}
+}
+
+// user is the key holding the user with id
+func (k keyspace) user(id int) string {
+	return k.prefix + strconv.Itoa(id)
+}
+
+// email is the key holding the id of the user with email
+func (k keyspace) email(email string) string {
+	return k.prefix + "email:" + normalizeEmail(email)
+}
+
+// missing is the key recording that no user has id. It lives beside the
+// user entry rather than in it, so a user entry always decodes to a real
+// user.
+func (k keyspace) missing(id int) string {
+	return k.prefix + "missing:" + strconv.Itoa(id)
+}
+
+// lock is the load lock for key. It sits outside the user keys so a flush
+// leaves it alone.
+func (k keyspace) lock(key string) string {
+	return k.namespace + "lock:" + key[len(k.namespace):]
+}
+
+// all matches every user key in the namespace, of every schema version
+func (k keyspace) all() string {
+	return k.namespace + "user:*"
+}
+
+// channel is the pub/sub channel for L1 invalidations. It has no version:
+// ids mean the same whatever the layout, so instances mid-rollout still
+// invalidate each other's L1.
+func (k keyspace) channel() string {
+	return k.namespace + InvalidationChannel
+}
+
+// userKeys lists the entry, tombstone and email index keys for a user.
+// Empty emails are skipped.
+func (k keyspace) userKeys(id int, emails ...string) []string {
+	keys := []string{k.user(id), k.missing(id)}
+	for _, email := range emails {
+		if email != "" {
+			keys = append(keys, k.email(email))
+		}
+	}
+	return keys
+}
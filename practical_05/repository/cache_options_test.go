@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"practical5-example/repository/fixtures"
+	"testing"
+	"time"
+)
+
+func TestKeyspace(t *testing.T) {
+	t.Run("Default Layout", func(t *testing.T) {
+		keys := newKeyspace(CacheOptions{}.withDefaults())
+
+		for _, tt := range [][2]string{
+			{keys.user(7), "user:v2:7"},
+			{keys.email(" Ada@Example.com "), "user:v2:email:ada@example.com"},
+			{keys.missing(7), "user:v2:missing:7"},
+			{keys.lock(keys.user(7)), "lock:user:v2:7"},
+			{keys.all(), "user:*"},
+			{keys.channel(), "user:invalidate"},
+		} {
+			if got, want := tt[0], tt[1]; got != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		}
+	})
+
+	t.Run("Namespace And Version", func(t *testing.T) {
+		keys := newKeyspace(CacheOptions{Namespace: "tenant42", SchemaVersion: 3}.withDefaults())
+
+		for _, tt := range [][2]string{
+			{keys.user(7), "tenant42:user:v3:7"},
+			{keys.lock(keys.user(7)), "tenant42:lock:user:v3:7"},
+			{keys.all(), "tenant42:user:*"},
+			{keys.channel(), "tenant42:user:invalidate"},
+		} {
+			if got, want := tt[0], tt[1]; got != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		}
+	})
+}
+
+func TestCacheOptionsTTL(t *testing.T) {
+	t.Run("Jitter Stays In Range", func(t *testing.T) {
+		opts := CacheOptions{Jitter: 0.5}.withDefaults()
+		seen := make(map[time.Duration]bool)
+
+		for i := 0; i < 100; i++ {
+			ttl := opts.ttl(time.Minute)
+			if ttl < time.Minute || ttl > 90*time.Second {
+				t.Fatalf("Expected a TTL in [1m, 1m30s], got %v", ttl)
+			}
+			seen[ttl] = true
+		}
+		if len(seen) < 2 {
+			t.Error("Expected jitter to vary the TTL")
+		}
+	})
+
+	t.Run("Negative Jitter Disables It", func(t *testing.T) {
+		opts := CacheOptions{Jitter: -1}.withDefaults()
+		if ttl := opts.ttl(time.Minute); ttl != time.Minute {
+			t.Errorf("Expected exactly 1m, got %v", ttl)
+		}
+	})
+}
+
+func TestCachedOptions(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+
+	opts := CacheOptions{Namespace: "tenant-a", ReadTTL: time.Minute, WriteTTL: 10 * time.Minute, Jitter: -1}
+	repo := NewCachedUserStoreWithOptions(base, rdb, opts)
+
+	t.Run("Read And Write TTLs", func(t *testing.T) {
+		f := fixtures.New(t, base)
+		written, err := repo.CreateCached(ctx, f.Email(), "Write TTL")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		defer repo.DeleteCached(ctx, written.ID)
+
+		if ttl, _ := rdb.TTL(ctx, repo.keys.user(written.ID)).Result(); ttl <= time.Minute || ttl > 10*time.Minute {
+			t.Errorf("Expected the write TTL on a created user, got %v", ttl)
+		}
+
+		loaded := f.Create(fixtures.User{})
+		repo.GetByIDCached(ctx, loaded.ID)
+		if ttl, _ := rdb.TTL(ctx, repo.keys.user(loaded.ID)).Result(); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("Expected the read TTL on a loaded user, got %v", ttl)
+		}
+	})
+
+	t.Run("Namespaces Are Isolated", func(t *testing.T) {
+		f := fixtures.New(t, base)
+		user := f.Create(fixtures.User{})
+		other := NewCachedUserStoreWithOptions(base, rdb, CacheOptions{Namespace: "tenant-b"})
+		repo.GetByIDCached(ctx, user.ID)
+		other.GetByIDCached(ctx, user.ID)
+
+		repo.UpdateCached(ctx, user.ID, f.Email(), "Renamed")
+
+		if exists, _ := rdb.Exists(ctx, other.keys.user(user.ID)).Result(); exists != 1 {
+			t.Error("Expected another namespace's entry to be untouched")
+		}
+		if exists, _ := rdb.Exists(ctx, repo.keys.user(user.ID)).Result(); exists != 0 {
+			t.Error("Expected this namespace's entry to be invalidated")
+		}
+	})
+
+	t.Run("Version Bump Ignores Old Entries", func(t *testing.T) {
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		repo.GetByIDCached(ctx, user.ID)
+
+		next := opts
+		next.SchemaVersion = CacheSchemaVersion + 1
+		upgraded := NewCachedUserStoreWithOptions(base, rdb, next)
+
+		upgraded.GetByIDCached(ctx, user.ID)
+		if stats := upgraded.Stats(); stats.Hits != 0 || stats.Misses != 1 {
+			t.Errorf("Expected the new version to miss, got %+v", stats)
+		}
+	})
+}
@@ -3,93 +3,630 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 	"practical5-example/models"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
-// CachedUserRepository wraps UserRepository with Redis caching
+// CachedUserRepository wraps a UserStore with Redis caching. A user is
+// cached under its id, and an index key for its normalized email holds
+// the id so email lookups can reach the same entry; CacheOptions sets the
+// key layout and TTLs. Both keys are written and removed together in
+// MULTI/EXEC pipelines, so readers never see one without the other being
+// written in the same step.
+//
+// Misses on a user entry are coalesced so each process loads a key once at a
+// time, and hot keys are refreshed shortly before they expire; see
+// stampede.go. An optional in-process L1 cache sits in front of Redis;
+// see l1.go.
+//
+// Cache writes and invalidations that follow a store write happen only
+// once that write is committed. Over a repository bound to a transaction
+// they are deferred with AfterCommit, and dropped if it rolls back.
 type CachedUserRepository struct {
-	repo  *UserRepository
+	store UserStore
+	// cacheState is shared with repositories bound to transactions by
+	// RunInTx, which only swap the store
+	*cacheState
+}
+
+// cacheState is everything about a cached repository but its store
+type cacheState struct {
 	cache *redis.Client
+	opts  CacheOptions
+	keys  keyspace
+
+	flight      singleflight.Group
+	lockTTL     time.Duration
+	beta        float64
+	negativeTTL time.Duration
+	stats       cacheCounters
+
+	l1          *lru
+	unsubscribe func() error
+	listening   sync.WaitGroup
+
+	breaker   breaker
+	pending   *pendingInvalidations
+	replaying atomic.Bool
 }
 
-// NewCachedUserRepository creates a cached repository
+// NewCachedUserRepository creates a cached repository over Postgres
 func NewCachedUserRepository(db *sql.DB, cache *redis.Client) *CachedUserRepository {
+	return NewCachedUserStore(NewUserRepository(db), cache)
+}
+
+// NewCachedUserStore creates a cached repository over any UserStore with
+// the default CacheOptions
+func NewCachedUserStore(store UserStore, cache *redis.Client) *CachedUserRepository {
+	return NewCachedUserStoreWithOptions(store, cache, CacheOptions{})
+}
+
+// NewCachedUserStoreWithOptions creates a cached repository over any
+// UserStore with the given key layout and expiry policy. Fields of opts
+// left zero take their defaults.
+//
+// It panics if store is a UserRepository made over a *sql.Tx: the cache
+// cannot tell when that transaction commits, so it would cache and evict
+// rows that may yet be rolled back. Use RunInTx to work in a transaction.
+func NewCachedUserStoreWithOptions(store UserStore, cache *redis.Client, opts CacheOptions) *CachedUserRepository {
+	if repo, ok := store.(*UserRepository); ok && repo.foreignTx() {
+		panic("repository: cannot cache a UserRepository made over a *sql.Tx; use CachedUserRepository.RunInTx")
+	}
+
+	opts = opts.withDefaults()
 	return &CachedUserRepository{
-		repo:  NewUserRepository(db),
-		cache: cache,
+		store: store,
+		cacheState: &cacheState{
+			cache: cache,
+			opts:  opts,
+			keys:  newKeyspace(opts),
+			beta:  DefaultEarlyRefreshBeta,
+
+			negativeTTL: DefaultNegativeTTL,
+
+			breaker: breaker{threshold: DefaultBreakerThreshold, cooldown: DefaultBreakerCooldown},
+			pending: newPendingInvalidations(DefaultMaxPendingInvalidations),
+		},
+	}
+}
+
+// txStore is implemented by stores that can defer work until their
+// transaction commits; *UserRepository is one
+type txStore interface {
+	InTx() bool
+	AfterCommit(fn func())
+}
+
+// inTx reports whether the store is bound to an uncommitted transaction.
+// Reads then go straight to it: the cache holds committed data only, and
+// nothing the transaction reads may be cached before it commits.
+func (r *CachedUserRepository) inTx() bool {
+	tx, ok := r.store.(txStore)
+	return ok && tx.InTx()
+}
+
+// afterCommit runs fn once the store's last write is committed: straight
+// away, or when the store's transaction commits. fn's context is not
+// cancelled with ctx, since the write it follows has happened either way.
+func (r *CachedUserRepository) afterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	if tx, ok := r.store.(txStore); ok && tx.InTx() {
+		tx.AfterCommit(func() { fn(ctx) })
+		return
+	}
+	fn(ctx)
+}
+
+// RunInTx runs fn in a Postgres transaction with a cached repository bound
+// to it. txRepo reads from the transaction rather than the cache, and the
+// cache is only updated for its writes once the transaction commits. The
+// store must be a *UserRepository.
+func (r *CachedUserRepository) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(txRepo *CachedUserRepository) error) error {
+	repo, ok := r.store.(*UserRepository)
+	if !ok {
+		return fmt.Errorf("transactions require a *UserRepository store, got %T", r.store)
+	}
+	return repo.RunInTx(ctx, opts, func(tx *UserRepository) error {
+		return fn(&CachedUserRepository{store: tx, cacheState: r.cacheState})
+	})
+}
+
+// RunInTxWithRetry behaves like RunInTx but retries serialization failures
+// and deadlocks as UserRepository.RunInTxWithRetry does. Only the attempt
+// that commits touches the cache.
+func (r *CachedUserRepository) RunInTxWithRetry(ctx context.Context, opts RetryOptions, fn func(txRepo *CachedUserRepository) error) (int, error) {
+	repo, ok := r.store.(*UserRepository)
+	if !ok {
+		return 0, fmt.Errorf("transactions require a *UserRepository store, got %T", r.store)
+	}
+	return repo.RunInTxWithRetry(ctx, opts, func(tx *UserRepository) error {
+		return fn(&CachedUserRepository{store: tx, cacheState: r.cacheState})
+	})
+}
+
+// normalizeEmail folds case and surrounding space. The store compares
+// emails exactly, so two users can share a normalized email; readers check
+// the cached user's email before trusting the index.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// cached returns the entry stored under key, or nil on a miss
+func (r *CachedUserRepository) cached(ctx context.Context, key string) *CacheEntry {
+	var data []byte
+	err := r.guard(ctx, func() (err error) {
+		data, err = r.cache.Get(ctx, key).Bytes()
+		return err
+	})
+	if err != nil {
+		return nil
+	}
+	return r.decode(ctx, key, data)
+}
+
+// decode unwraps the entry stored under key. An entry that cannot be
+// decoded is counted and deleted, and reads as a miss.
+func (r *CachedUserRepository) decode(ctx context.Context, key string, data []byte) *CacheEntry {
+	entry, err := decodeEntry(r.opts.Codec, data)
+	if err == nil {
+		return entry
+	}
+
+	if errors.Is(err, errIncompatibleEntry) {
+		r.stats.incompatible.Add(1)
+	} else {
+		r.stats.corrupt.Add(1)
 	}
+	r.guard(ctx, func() error {
+		return compareAndDelete.Run(ctx, r.cache, []string{key}, data).Err()
+	})
+	return nil
+}
+
+// cacheUser writes a user that was just changed, together with its email
+// index, dropping the index of any previous email the user had in the same
+// transaction. Other instances are told to drop the user from their L1.
+func (r *CachedUserRepository) cacheUser(ctx context.Context, user *models.User, previous ...string) {
+	r.writeUser(ctx, user, 0, true, previous...)
 }
 
-// GetByIDCached retrieves user by ID with caching
+// writeUser caches an entry that took delta to load, announcing it if it
+// changed. Any tombstone for the user's id is removed in the same
+// transaction. If a changed user cannot be written, its old keys are
+// queued for deletion instead so they cannot serve the previous version.
+func (r *CachedUserRepository) writeUser(ctx context.Context, user *models.User, delta time.Duration, changed bool, previous ...string) {
+	ttl := r.opts.ReadTTL
+	if changed {
+		ttl = r.opts.WriteTTL
+	}
+	ttl = r.opts.ttl(ttl)
+
+	data, err := encodeEntry(r.opts.Codec, r.opts.CompressAbove, &CacheEntry{User: user, Delta: delta, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		// Without an entry to write, make sure no older one survives
+		if changed {
+			r.evict(ctx, user.ID, append(previous, user.Email)...)
+		}
+		return
+	}
+
+	err = r.guard(ctx, func() error {
+		_, err := r.cache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if changed {
+				r.announce(ctx, pipe, user.ID)
+			}
+			for _, email := range previous {
+				if email != "" && normalizeEmail(email) != normalizeEmail(user.Email) {
+					pipe.Del(ctx, r.keys.email(email))
+				}
+			}
+			pipe.Del(ctx, r.keys.missing(user.ID))
+			pipe.Set(ctx, r.keys.user(user.ID), data, ttl)
+			pipe.Set(ctx, r.keys.email(user.Email), user.ID, ttl)
+			return nil
+		})
+		return err
+	})
+
+	if err != nil && changed {
+		r.pending.add(r.keys.userKeys(user.ID, append(previous, user.Email)...), []int{user.ID})
+	}
+}
+
+// evict removes the user entry, its tombstone and the index for each email
+// together, queueing them for replay if Redis is unavailable
+func (r *CachedUserRepository) evict(ctx context.Context, id int, emails ...string) {
+	r.invalidate(ctx, r.keys.userKeys(id, emails...), []int{id})
+}
+
+// currentEmail returns the email id has before a write changes or removes
+// it, reading the cache first and the store on a miss. It returns "" if
+// the user cannot be found; the write itself will report why.
+func (r *CachedUserRepository) currentEmail(ctx context.Context, id int) string {
+	if !r.inTx() {
+		if entry := r.cached(ctx, r.keys.user(id)); entry != nil {
+			return entry.User.Email
+		}
+	}
+	if user, err := r.store.GetByID(ctx, id); err == nil {
+		return user.Email
+	}
+	return ""
+}
+
+// GetByIDCached retrieves user by ID with caching. Concurrent misses on
+// the same id share one store query, and a hit close to expiry may reload
+// the entry early; if that reload fails the cached user is still returned.
+// Ids the store reported missing are answered with ErrNotFound from a
+// short-lived tombstone. With an L1 cache, users found in Redis or the
+// store are kept in process as well. Inside a transaction the store is
+// read directly.
 func (r *CachedUserRepository) GetByIDCached(ctx context.Context, id int) (*models.User, error) {
-	cacheKey := fmt.Sprintf("user:%d", id)
+	if r.inTx() {
+		return r.store.GetByID(ctx, id)
+	}
+	if r.l1 == nil {
+		return r.getByID(ctx, id)
+	}
+
+	if user, ok := r.l1.get(id); ok {
+		r.stats.l1Hits.Add(1)
+		return user, nil
+	}
+
+	user, err := r.getByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	r.l1.put(user)
+	return user, nil
+}
 
+// getByID reads id through Redis
+func (r *CachedUserRepository) getByID(ctx context.Context, id int) (*models.User, error) {
 	// Try cache first
-	cached, err := r.cache.Get(ctx, cacheKey).Result()
+	entry, missing := r.lookup(ctx, id)
+	if missing {
+		r.stats.negativeHits.Add(1)
+		return nil, notFound("id", id)
+	}
+	if entry != nil {
+		r.stats.hits.Add(1)
+		if !entry.refreshEarly(r.beta) {
+			return entry.User, nil
+		}
+
+		r.stats.earlyRefreshes.Add(1)
+		if user, err := r.loadShared(ctx, id); err == nil {
+			return user, nil
+		}
+		return entry.User, nil
+	}
+
+	// Cache miss - query database once for every waiting request
+	r.stats.misses.Add(1)
+	return r.loadShared(ctx, id)
+}
+
+// GetByEmailCached retrieves user by email with caching. The email index
+// leads to the user entry; if either is missing, or the entry belongs to a
+// different email, the store is queried and both keys are refilled.
+// Inside a transaction the store is read directly.
+func (r *CachedUserRepository) GetByEmailCached(ctx context.Context, email string) (*models.User, error) {
+	if r.inTx() {
+		return r.store.GetByEmail(ctx, email)
+	}
+
+	// Try cache first
+	var id int
+	err := r.guard(ctx, func() (err error) {
+		id, err = r.cache.Get(ctx, r.keys.email(email)).Int()
+		return err
+	})
 	if err == nil {
-		var user models.User
-		if err := json.Unmarshal([]byte(cached), &user); err == nil {
-			return &user, nil
+		if entry := r.cached(ctx, r.keys.user(id)); entry != nil && entry.User.Email == email {
+			return entry.User, nil
 		}
 	}
 
 	// Cache miss - query database
-	user, err := r.repo.GetByID(id)
+	user, err := r.store.GetByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 
-	// Store in cache
-	data, _ := json.Marshal(user)
-	r.cache.Set(ctx, cacheKey, data, 5*time.Minute)
+	// Store in cache; nothing changed, so there is nothing to announce
+	r.writeUser(ctx, user, 0, false)
 
 	return user, nil
 }
 
 // CreateCached creates a user and caches it
 func (r *CachedUserRepository) CreateCached(ctx context.Context, email, name string) (*models.User, error) {
-	user, err := r.repo.Create(email, name)
+	user, err := r.store.Create(ctx, email, name)
 	if err != nil {
 		return nil, err
 	}
 
 	// Cache the new user
-	cacheKey := fmt.Sprintf("user:%d", user.ID)
-	data, _ := json.Marshal(user)
-	r.cache.Set(ctx, cacheKey, data, 5*time.Minute)
+	r.afterCommit(ctx, func(ctx context.Context) { r.cacheUser(ctx, user) })
 
 	return user, nil
 }
 
-// UpdateCached updates a user and invalidates cache
+// UpsertByEmailCached upserts a user and refreshes its cache entry
+func (r *CachedUserRepository) UpsertByEmailCached(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error) {
+	user, inserted, err := r.store.UpsertByEmail(ctx, email, name, policy)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Overwrite whatever was cached with the merged row. Upserts match on
+	// email, so the email index cannot have moved.
+	r.afterCommit(ctx, func(ctx context.Context) { r.cacheUser(ctx, user) })
+
+	return user, inserted, nil
+}
+
+// UpdateCached updates a user and invalidates cache, including the index
+// for the email the user had before the update
 func (r *CachedUserRepository) UpdateCached(ctx context.Context, id int, email, name string) error {
-	err := r.repo.Update(id, email, name)
+	oldEmail := r.currentEmail(ctx, id)
+
+	err := r.store.Update(ctx, id, email, name)
 	if err != nil {
 		return err
 	}
 
 	// Invalidate cache
-	cacheKey := fmt.Sprintf("user:%d", id)
-	r.cache.Del(ctx, cacheKey)
+	r.afterCommit(ctx, func(ctx context.Context) { r.evict(ctx, id, oldEmail, email) })
 
 	return nil
 }
 
-// DeleteCached deletes a user and invalidates cache
+// UpdateIfVersionCached performs an optimistic update and caches the new
+// row. On a version conflict the cached entry is evicted, since it is the
+// likely source of the stale version.
+func (r *CachedUserRepository) UpdateIfVersionCached(ctx context.Context, id, version int, email, name string) (*models.User, error) {
+	oldEmail := r.currentEmail(ctx, id)
+
+	user, err := r.store.UpdateIfVersion(ctx, id, version, email, name)
+	if err != nil {
+		// Nothing was written, so there is no commit to wait for
+		if errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+			r.evict(ctx, id, oldEmail)
+		}
+		return nil, err
+	}
+
+	r.afterCommit(ctx, func(ctx context.Context) { r.cacheUser(ctx, user, oldEmail) })
+
+	return user, nil
+}
+
+// DeleteCached deletes a user and invalidates both cache keys
 func (r *CachedUserRepository) DeleteCached(ctx context.Context, id int) error {
-	err := r.repo.Delete(id)
+	email := r.currentEmail(ctx, id)
+
+	err := r.store.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	r.afterCommit(ctx, func(ctx context.Context) { r.evict(ctx, id, email) })
+
+	return nil
+}
+
+// SoftDeleteCached soft-deletes a user and invalidates both cache keys
+func (r *CachedUserRepository) SoftDeleteCached(ctx context.Context, id int) error {
+	email := r.currentEmail(ctx, id)
+
+	err := r.store.SoftDelete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	r.afterCommit(ctx, func(ctx context.Context) { r.evict(ctx, id, email) })
+
+	return nil
+}
+
+// RestoreCached restores a soft-deleted user and invalidates cache,
+// including any tombstone cached while the user was deleted. The email
+// index was cleared by the soft delete.
+func (r *CachedUserRepository) RestoreCached(ctx context.Context, id int) error {
+	err := r.store.Restore(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	r.afterCommit(ctx, func(ctx context.Context) { r.evict(ctx, id) })
+
+	return nil
+}
+
+// The methods below make CachedUserRepository a UserStore itself, so it can
+// stand in anywhere a store is expected. Single-user reads go through the
+// cache and every write updates or invalidates it; list and search reads
+// go to the underlying store directly.
+
+// GetByID retrieves a user by ID through the cache
+func (r *CachedUserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
+	return r.GetByIDCached(ctx, id)
+}
+
+// GetByEmail retrieves a user by email through the cache
+func (r *CachedUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.GetByEmailCached(ctx, email)
+}
+
+// Create inserts a user and caches it
+func (r *CachedUserRepository) Create(ctx context.Context, email, name string) (*models.User, error) {
+	return r.CreateCached(ctx, email, name)
+}
+
+// Update modifies a user and invalidates cache
+func (r *CachedUserRepository) Update(ctx context.Context, id int, email, name string) error {
+	return r.UpdateCached(ctx, id, email, name)
+}
+
+// UpdateIfVersion performs an optimistic update through the cache
+func (r *CachedUserRepository) UpdateIfVersion(ctx context.Context, id, version int, email, name string) (*models.User, error) {
+	return r.UpdateIfVersionCached(ctx, id, version, email, name)
+}
+
+// UpsertByEmail upserts a user and refreshes its cache entry
+func (r *CachedUserRepository) UpsertByEmail(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error) {
+	return r.UpsertByEmailCached(ctx, email, name, policy)
+}
+
+// Delete removes a user and invalidates cache
+func (r *CachedUserRepository) Delete(ctx context.Context, id int) error {
+	return r.DeleteCached(ctx, id)
+}
+
+// SoftDelete soft-deletes a user and invalidates cache
+func (r *CachedUserRepository) SoftDelete(ctx context.Context, id int) error {
+	return r.SoftDeleteCached(ctx, id)
+}
+
+// Restore restores a soft-deleted user and invalidates cache
+func (r *CachedUserRepository) Restore(ctx context.Context, id int) error {
+	return r.RestoreCached(ctx, id)
+}
+
+// Purge removes old soft-deleted users. They were evicted when they were
+// soft-deleted, so there is nothing to invalidate.
+func (r *CachedUserRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return r.store.Purge(ctx, olderThan)
+}
+
+// List retrieves all users from the store
+func (r *CachedUserRepository) List(ctx context.Context) ([]models.User, error) {
+	return r.store.List(ctx)
+}
+
+// FindByNamePattern finds users by name pattern in the store
+func (r *CachedUserRepository) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	return r.store.FindByNamePattern(ctx, pattern)
+}
+
+// CountUsers counts users in the store
+func (r *CachedUserRepository) CountUsers(ctx context.Context) (int, error) {
+	return r.store.CountUsers(ctx)
+}
+
+// GetRecentUsers returns recently created users from the store
+func (r *CachedUserRepository) GetRecentUsers(ctx context.Context, days int) ([]models.User, error) {
+	return r.store.GetRecentUsers(ctx, days)
+}
+
+// Find returns one page of matching users from the store
+func (r *CachedUserRepository) Find(ctx context.Context, filter UserFilter, req PageRequest) (Page, error) {
+	return r.store.Find(ctx, filter, req)
+}
+
+// ListPage returns one page of users from the store
+func (r *CachedUserRepository) ListPage(ctx context.Context, req PageRequest) (Page, error) {
+	return r.store.ListPage(ctx, req)
+}
+
+// FindByNamePatternPage returns one page of users by name pattern from the store
+func (r *CachedUserRepository) FindByNamePatternPage(ctx context.Context, pattern string, req PageRequest) (Page, error) {
+	return r.store.FindByNamePatternPage(ctx, pattern, req)
+}
+
+// GetRecentUsersPage returns one page of recent users from the store
+func (r *CachedUserRepository) GetRecentUsersPage(ctx context.Context, days int, req PageRequest) (Page, error) {
+	return r.store.GetRecentUsersPage(ctx, days, req)
+}
+
+// Stream yields matching users from the store
+func (r *CachedUserRepository) Stream(ctx context.Context, filter UserFilter, opts StreamOptions) iter.Seq2[models.User, error] {
+	return r.store.Stream(ctx, filter, opts)
+}
+
+// batchIDStore is implemented by stores whose BatchCreate can report the
+// ids it assigned; every store in this package is one
+type batchIDStore interface {
+	BatchCreateIDs(ctx context.Context, users []struct{ Email, Name string }) ([]int, error)
+}
+
+// BatchCreate creates users in the store and clears any tombstones cached
+// for their new ids. The users themselves are cached by the first read. A
+// store that cannot report the ids leaves its tombstones to expire.
+func (r *CachedUserRepository) BatchCreate(ctx context.Context, users []struct{ Email, Name string }) error {
+	store, ok := r.store.(batchIDStore)
+	if !ok {
+		return r.store.BatchCreate(ctx, users)
+	}
+
+	ids, err := store.BatchCreateIDs(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) > 0 {
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = r.keys.missing(id)
+		}
+		r.afterCommit(ctx, func(ctx context.Context) { r.invalidate(ctx, keys, nil) })
+	}
+
+	return nil
+}
+
+// BulkImport imports users and clears the tombstones of every user it
+// wrote. With DuplicateUpsert it also invalidates them, since an upsert may
+// have changed users that are cached.
+func (r *CachedUserRepository) BulkImport(ctx context.Context, users iter.Seq2[UserInput, error], opts BulkImportOptions) (BulkImportResult, error) {
+	result, err := r.store.BulkImport(ctx, users, opts)
+	if err != nil {
+		return result, err
+	}
+
+	if len(result.IDs) > 0 {
+		keys := make([]string, 0, 2*len(result.IDs))
+		for _, id := range result.IDs {
+			keys = append(keys, r.keys.missing(id))
+			if opts.OnDuplicate == DuplicateUpsert {
+				keys = append(keys, r.keys.user(id))
+			}
+		}
+		var ids []int
+		if opts.OnDuplicate == DuplicateUpsert {
+			ids = result.IDs
+		}
+		r.afterCommit(ctx, func(ctx context.Context) { r.invalidate(ctx, keys, ids) })
+	}
+
+	return result, nil
+}
+
+// TransferUserData copies a user's name onto another user and invalidates
+// the target's cache entry. Emails do not change, so the index stays valid.
+func (r *CachedUserRepository) TransferUserData(ctx context.Context, fromID, toID int) error {
+	err := r.store.TransferUserData(ctx, fromID, toID)
 	if err != nil {
 		return err
 	}
 
 	// Invalidate cache
-	cacheKey := fmt.Sprintf("user:%d", id)
-	r.cache.Del(ctx, cacheKey)
+	r.afterCommit(ctx, func(ctx context.Context) { r.evict(ctx, toID) })
 
 	return nil
 }
@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"practical5-example/models"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Codec turns cache entries into bytes and back. Every entry is wrapped in
+// an envelope recording the codec's ID, so an entry written by a different
+// codec is recognised and discarded rather than misread.
+type Codec interface {
+	// ID identifies the codec in the envelope. IDs below 16 are reserved
+	// for the codecs in this package.
+	ID() byte
+	Marshal(entry *CacheEntry) ([]byte, error)
+	Unmarshal(data []byte, entry *CacheEntry) error
+}
+
+// Built-in codec IDs
+const (
+	jsonCodecID        = 1
+	messagePackCodecID = 2
+	protobufCodecID    = 3
+)
+
+// JSONCodec stores entries as JSON. It is the default: the largest and
+// slowest of the built-in codecs, but readable with redis-cli.
+type JSONCodec struct{}
+
+func (JSONCodec) ID() byte { return jsonCodecID }
+
+func (JSONCodec) Marshal(entry *CacheEntry) ([]byte, error) {
+	return json.Marshal(entry)
+}
+
+func (JSONCodec) Unmarshal(data []byte, entry *CacheEntry) error {
+	return json.Unmarshal(data, entry)
+}
+
+// MessagePackCodec stores entries as MessagePack, keyed by the same field
+// names as JSON
+type MessagePackCodec struct{}
+
+func (MessagePackCodec) ID() byte { return messagePackCodecID }
+
+func (MessagePackCodec) Marshal(entry *CacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MessagePackCodec) Unmarshal(data []byte, entry *CacheEntry) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(entry)
+}
+
+// ProtobufCodec stores entries in the protobuf wire format, the most
+// compact of the built-in codecs. The messages are encoded by hand, so no
+// generated code is needed; their schema is
+//
+//	message CacheEntry {
+//	  User user = 1;
+//	  int64 delta = 2;   // nanoseconds
+//	  int64 expires = 3; // Unix nanoseconds
+//	}
+//
+//	message User {
+//	  int64 id = 1;
+//	  string email = 2;
+//	  string name = 3;
+//	  int64 created_at = 4; // Unix nanoseconds
+//	  int64 version = 5;
+//	  int64 deleted_at = 6; // Unix nanoseconds, only while soft-deleted
+//	}
+//
+// Zero times are left out, as are all other zero values.
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ID() byte { return protobufCodecID }
+
+func (ProtobufCodec) Marshal(entry *CacheEntry) ([]byte, error) {
+	var b []byte
+	if entry.User != nil {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendProtoUser(nil, entry.User))
+	}
+	b = appendProtoInt(b, 2, int64(entry.Delta))
+	b = appendProtoTime(b, 3, entry.Expires)
+	return b, nil
+}
+
+func appendProtoUser(b []byte, user *models.User) []byte {
+	b = appendProtoInt(b, 1, int64(user.ID))
+	if user.Email != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, user.Email)
+	}
+	if user.Name != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, user.Name)
+	}
+	b = appendProtoTime(b, 4, user.CreatedAt)
+	b = appendProtoInt(b, 5, int64(user.Version))
+	if user.DeletedAt != nil {
+		b = appendProtoTime(b, 6, *user.DeletedAt)
+	}
+	return b
+}
+
+func appendProtoInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendProtoTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return appendProtoInt(b, num, t.UnixNano())
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, entry *CacheEntry) error {
+	*entry = CacheEntry{}
+	return consumeProto(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+			entry.User = &models.User{}
+			return n, unmarshalProtoUser(v, entry.User)
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			entry.Delta = time.Duration(v)
+			return n, nil
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			entry.Expires = time.Unix(0, int64(v))
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+func unmarshalProtoUser(data []byte, user *models.User) error {
+	return consumeProto(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case (num == 2 || num == 3) && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if num == 2 {
+				user.Email = v
+			} else {
+				user.Name = v
+			}
+			return n, nil
+		case typ == protowire.VarintType && num >= 1 && num <= 6:
+			v, n := protowire.ConsumeVarint(b)
+			switch num {
+			case 1:
+				user.ID = int(v)
+			case 4:
+				user.CreatedAt = time.Unix(0, int64(v))
+			case 5:
+				user.Version = int(v)
+			case 6:
+				deletedAt := time.Unix(0, int64(v))
+				user.DeletedAt = &deletedAt
+			}
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+// consumeProto walks the fields of a message. field consumes one value and
+// returns its length, which protowire reports as negative on bad input.
+func consumeProto(data []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		n, err := field(num, typ, data)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// An envelope is a four byte header followed by the codec's payload:
+//
+//	magic | envelope version | codec ID | flags
+//
+// The payload is deflated when flagCompressed is set.
+const (
+	envelopeMagic   = 0xCE
+	envelopeVersion = 1
+	envelopeHeader  = 4
+
+	flagCompressed = 1 << 0
+)
+
+var (
+	// errCorruptEntry means a cached value could not be decoded at all
+	errCorruptEntry = errors.New("corrupt cache entry")
+	// errIncompatibleEntry means a cached value is intact but was written
+	// by another codec or envelope version
+	errIncompatibleEntry = errors.New("incompatible cache entry")
+)
+
+var deflaters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// encodeEntry marshals entry with codec into an envelope, compressing
+// payloads longer than compressAbove bytes unless it is zero
+func encodeEntry(codec Codec, compressAbove int, entry *CacheEntry) ([]byte, error) {
+	payload, err := codec.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte{envelopeMagic, envelopeVersion, codec.ID(), 0}
+	if compressAbove <= 0 || len(payload) <= compressAbove {
+		return append(header, payload...), nil
+	}
+
+	header[3] |= flagCompressed
+	buf := bytes.NewBuffer(header)
+	w := deflaters.Get().(*flate.Writer)
+	defer deflaters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeEntry unwraps an envelope written by codec. Its errors wrap
+// errCorruptEntry or errIncompatibleEntry.
+func decodeEntry(codec Codec, data []byte) (*CacheEntry, error) {
+	if len(data) < envelopeHeader || data[0] != envelopeMagic {
+		return nil, fmt.Errorf("%w: missing envelope", errCorruptEntry)
+	}
+	if data[1] != envelopeVersion || data[2] != codec.ID() {
+		return nil, fmt.Errorf("%w: envelope version %d, codec %d", errIncompatibleEntry, data[1], data[2])
+	}
+
+	payload := data[envelopeHeader:]
+	if data[3]&flagCompressed != 0 {
+		var err error
+		payload, err = io.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorruptEntry, err)
+		}
+	}
+
+	var entry CacheEntry
+	if err := codec.Unmarshal(payload, &entry); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptEntry, err)
+	}
+	if entry.User == nil {
+		return nil, fmt.Errorf("%w: no user", errCorruptEntry)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"practical5-example/models"
+	"practical5-example/repository/fixtures"
+	"strings"
+	"testing"
+	"time"
+)
+
+var codecs = []struct {
+	name  string
+	codec Codec
+}{
+	{"JSON", JSONCodec{}},
+	{"MessagePack", MessagePackCodec{}},
+	{"Protobuf", ProtobufCodec{}},
+}
+
+func sameEntry(a, b *CacheEntry) bool {
+	ua, ub := a.User, b.User
+	deleted := (ua.DeletedAt == nil) == (ub.DeletedAt == nil)
+	if deleted && ua.DeletedAt != nil {
+		deleted = ua.DeletedAt.Equal(*ub.DeletedAt)
+	}
+	return ua.ID == ub.ID && ua.Email == ub.Email && ua.Name == ub.Name &&
+		ua.CreatedAt.Equal(ub.CreatedAt) && ua.Version == ub.Version && deleted &&
+		a.Delta == b.Delta && a.Expires.Equal(b.Expires)
+}
+
+func TestCodecs(t *testing.T) {
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC)
+	entries := map[string]*CacheEntry{
+		"Live User": {
+			User:    &models.User{ID: 7, Email: "ada@example.com", Name: "Ada Lovelace", CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), Version: 3},
+			Delta:   1500 * time.Microsecond,
+			Expires: time.Date(2024, 1, 2, 3, 9, 5, 0, time.UTC),
+		},
+		"Deleted User": {
+			User:    &models.User{ID: 8, Email: "gone@example.com", Name: "Gone", Version: 1, DeletedAt: &deletedAt},
+			Expires: time.Date(2024, 5, 1, 12, 5, 0, 0, time.UTC),
+		},
+	}
+
+	for _, c := range codecs {
+		for name, entry := range entries {
+			t.Run(c.name+" "+name, func(t *testing.T) {
+				data, err := encodeEntry(c.codec, 0, entry)
+				if err != nil {
+					t.Fatalf("Failed to encode: %v", err)
+				}
+
+				decoded, err := decodeEntry(c.codec, data)
+				if err != nil {
+					t.Fatalf("Failed to decode: %v", err)
+				}
+				if !sameEntry(entry, decoded) {
+					t.Errorf("Expected %+v, got %+v", entry.User, decoded.User)
+				}
+			})
+		}
+	}
+
+	t.Run("Compression", func(t *testing.T) {
+		entry := &CacheEntry{User: &models.User{ID: 1, Email: "long@example.com", Name: strings.Repeat("Wolfeschlegelsteinhausen", 20)}}
+
+		plain, _ := encodeEntry(JSONCodec{}, 0, entry)
+		compressed, err := encodeEntry(JSONCodec{}, 64, entry)
+		if err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+
+		if compressed[3]&flagCompressed == 0 || len(compressed) >= len(plain) {
+			t.Errorf("Expected a smaller compressed entry, got %d bytes from %d", len(compressed), len(plain))
+		}
+
+		decoded, err := decodeEntry(JSONCodec{}, compressed)
+		if err != nil || decoded.User.Name != entry.User.Name {
+			t.Errorf("Expected compressed entry to round trip, got %v", err)
+		}
+
+		small, _ := encodeEntry(JSONCodec{}, 1<<20, entry)
+		if !bytes.Equal(small, plain) {
+			t.Error("Expected entries under the threshold to be stored as is")
+		}
+	})
+}
+
+func TestDecodeEntryErrors(t *testing.T) {
+	entry := &CacheEntry{User: &models.User{ID: 1, Email: "a@example.com", Name: "A"}}
+	valid, _ := encodeEntry(JSONCodec{}, 0, entry)
+	compressed, _ := encodeEntry(JSONCodec{}, 1, entry)
+
+	newerEnvelope := bytes.Clone(valid)
+	newerEnvelope[1] = envelopeVersion + 1
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"Garbage", []byte("garbage"), errCorruptEntry},
+		{"Unwrapped JSON", []byte(`{"user":{"id":1}}`), errCorruptEntry},
+		{"Truncated Payload", valid[:len(valid)-3], errCorruptEntry},
+		{"Truncated Compression", compressed[:len(compressed)-3], errCorruptEntry},
+		{"No User", append([]byte{envelopeMagic, envelopeVersion, jsonCodecID, 0}, "{}"...), errCorruptEntry},
+		{"Other Codec", valid, errIncompatibleEntry},
+		{"Newer Envelope", newerEnvelope, errIncompatibleEntry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := Codec(JSONCodec{})
+			if tt.name == "Other Codec" {
+				codec = MessagePackCodec{}
+			}
+
+			if _, err := decodeEntry(codec, tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestCachedCodec(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+
+	for _, c := range codecs {
+		t.Run(c.name+" Round Trip", func(t *testing.T) {
+			repo := NewCachedUserStoreWithOptions(base, rdb, CacheOptions{Namespace: c.name, Codec: c.codec, CompressAbove: 32})
+			user := fixtures.New(t, base).Create(fixtures.User{})
+
+			repo.GetByIDCached(ctx, user.ID)
+			found, err := repo.GetByIDCached(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+
+			if stats := repo.Stats(); stats.Hits != 1 {
+				t.Errorf("Expected the second read to hit, got %+v", stats)
+			}
+			if found.Email != user.Email || !found.CreatedAt.Equal(user.CreatedAt) {
+				t.Errorf("Expected %+v, got %+v", user, found)
+			}
+		})
+	}
+
+	t.Run("Corrupt Entry Is Deleted", func(t *testing.T) {
+		repo := NewCachedUserStoreWithOptions(base, rdb, CacheOptions{Namespace: "corrupt"})
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		rdb.Set(ctx, repo.keys.user(user.ID), "not an entry", time.Minute)
+
+		found, err := repo.GetByIDCached(ctx, user.ID)
+		if err != nil || found.ID != user.ID {
+			t.Fatalf("Expected the user from the store, got %+v (err=%v)", found, err)
+		}
+
+		if stats := repo.Stats(); stats.Corrupt != 1 || stats.Loads != 1 {
+			t.Errorf("Expected 1 corrupt entry and a reload, got %+v", stats)
+		}
+
+		data, _ := rdb.Get(ctx, repo.keys.user(user.ID)).Bytes()
+		if _, err := decodeEntry(JSONCodec{}, data); err != nil {
+			t.Errorf("Expected the corrupt entry to be replaced, got: %v", err)
+		}
+	})
+
+	t.Run("Other Codec Is Incompatible", func(t *testing.T) {
+		opts := CacheOptions{Namespace: "switch"}
+		old := NewCachedUserStoreWithOptions(base, rdb, opts)
+		opts.Codec = ProtobufCodec{}
+		current := NewCachedUserStoreWithOptions(base, rdb, opts)
+
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		old.GetByIDCached(ctx, user.ID)
+		current.GetByIDCached(ctx, user.ID)
+
+		if stats := current.Stats(); stats.Incompatible != 1 || stats.Hits != 0 {
+			t.Errorf("Expected the JSON entry to be discarded, got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+package repository_test
+
+import (
+	"practical5-example/repository"
+	"practical5-example/repository/storetest"
+	"testing"
+)
+
+func TestPostgresConformance(t *testing.T) {
+	t.Parallel()
+	storetest.Run(t, func(t *testing.T) repository.UserStore {
+		return repository.NewUserRepository(repository.NewTestDB(t))
+	})
+}
+
+func TestCachedConformance(t *testing.T) {
+	t.Parallel()
+	storetest.Run(t, func(t *testing.T) repository.UserStore {
+		return repository.NewCachedUserStore(
+			repository.NewUserRepository(repository.NewTestDB(t)),
+			repository.NewTestRedis(t),
+		)
+	})
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Sentinel errors returned by the repository. They are always wrapped in an
+// *Error, so match them with errors.Is rather than comparing directly.
+var (
+	ErrNotFound       = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("email already in use")
+	ErrConflict       = errors.New("conflicting write")
+	ErrInvalidInput   = errors.New("invalid input")
+	ErrUnavailable    = errors.New("database unavailable")
+)
+
+// Error describes a failed repository call. Kind is one of the sentinel
+// errors above and Field/Value identify the offending input (for example
+// "id" and 42), so callers can map failures to status codes with errors.As.
+type Error struct {
+	Kind  error
+	Field string
+	Value interface{}
+	Err   error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Field != "" {
+		msg = fmt.Sprintf("%s (%s=%v)", msg, e.Field, e.Value)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// Unwrap exposes both the sentinel kind and the underlying driver error
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+func notFound(field string, value interface{}) error {
+	return &Error{Kind: ErrNotFound, Field: field, Value: value}
+}
+
+func invalidInput(field string, value interface{}, reason string) error {
+	return &Error{Kind: ErrInvalidInput, Field: field, Value: value, Err: errors.New(reason)}
+}
+
+// validateEmail performs a cheap sanity check on an email address
+func validateEmail(email string) error {
+	if strings.TrimSpace(email) == "" || !strings.Contains(email, "@") {
+		return invalidInput("email", email, "must be a valid email address")
+	}
+	return nil
+}
+
+// validateUser checks the fields accepted by Create and Update
+func validateUser(email, name string) error {
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+	if strings.TrimSpace(name) == "" {
+		return invalidInput("name", name, "must not be empty")
+	}
+	return nil
+}
+
+// classify maps Postgres and SQLite driver errors onto the repository error
+// taxonomy. Errors it does not recognise are returned unchanged.
+func classify(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Name() == "unique_violation" && strings.Contains(pqErr.Constraint, "email"):
+			return &Error{Kind: ErrDuplicateEmail, Field: "email", Value: keyValue(pqErr.Detail), Err: err}
+		case pqErr.Code.Name() == "unique_violation",
+			pqErr.Code.Class() == "40":
+			return &Error{Kind: ErrConflict, Field: pqErr.Column, Value: keyValue(pqErr.Detail), Err: err}
+		case pqErr.Code.Class() == "22", pqErr.Code.Class() == "23":
+			return &Error{Kind: ErrInvalidInput, Field: pqErr.Column, Err: err}
+		case pqErr.Code.Class() == "08", pqErr.Code.Class() == "53",
+			pqErr.Code == "57P01", pqErr.Code == "57P02", pqErr.Code == "57P03":
+			return &Error{Kind: ErrUnavailable, Err: err}
+		}
+		return err
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		// Extended result codes keep the primary code in the low byte
+		switch code := liteErr.Code(); {
+		case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(liteErr.Error(), "users.email"):
+			return &Error{Kind: ErrDuplicateEmail, Field: "email", Err: err}
+		case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE, code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY,
+			code&0xff == sqlite3.SQLITE_BUSY, code&0xff == sqlite3.SQLITE_LOCKED:
+			return &Error{Kind: ErrConflict, Err: err}
+		case code&0xff == sqlite3.SQLITE_CONSTRAINT, code&0xff == sqlite3.SQLITE_MISMATCH:
+			return &Error{Kind: ErrInvalidInput, Err: err}
+		case code&0xff == sqlite3.SQLITE_CANTOPEN, code&0xff == sqlite3.SQLITE_IOERR:
+			return &Error{Kind: ErrUnavailable, Err: err}
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr) {
+		return &Error{Kind: ErrUnavailable, Err: err}
+	}
+
+	return err
+}
+
+// keyValue extracts the value from a Postgres detail message such as
+// "Key (email)=(alice@example.com) already exists."
+func keyValue(detail string) string {
+	start := strings.Index(detail, ")=(")
+	if start < 0 {
+		return ""
+	}
+	rest := detail[start+3:]
+	end := strings.LastIndex(rest, ")")
+	if end < 0 {
+		return ""
+	}
+	return rest[:end]
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		kind  error
+		field string
+		value interface{}
+	}{
+		{
+			name:  "Duplicate Email",
+			err:   &pq.Error{Code: "23505", Constraint: "users_email_key", Detail: "Key (email)=(alice@example.com) already exists."},
+			kind:  ErrDuplicateEmail,
+			field: "email",
+			value: "alice@example.com",
+		},
+		{
+			name: "Serialization Failure",
+			err:  &pq.Error{Code: "40001"},
+			kind: ErrConflict,
+		},
+		{
+			name:  "Not Null Violation",
+			err:   &pq.Error{Code: "23502", Column: "name"},
+			kind:  ErrInvalidInput,
+			field: "name",
+		},
+		{
+			name: "Connection Failure",
+			err:  &pq.Error{Code: "08006"},
+			kind: ErrUnavailable,
+		},
+		{
+			name: "Bad Connection",
+			err:  fmt.Errorf("query failed: %w", driver.ErrBadConn),
+			kind: ErrUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classify(tt.err)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("Expected %v, got: %v", tt.kind, err)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Error("Expected original error to stay in the chain")
+			}
+
+			var repoErr *Error
+			if !errors.As(err, &repoErr) {
+				t.Fatalf("Expected *Error, got: %T", err)
+			}
+
+			if tt.field != "" && repoErr.Field != tt.field {
+				t.Errorf("Expected field %q, got: %q", tt.field, repoErr.Field)
+			}
+
+			if tt.value != nil && repoErr.Value != tt.value {
+				t.Errorf("Expected value %v, got: %v", tt.value, repoErr.Value)
+			}
+		})
+	}
+
+	t.Run("Unknown Error Unchanged", func(t *testing.T) {
+		original := errors.New("boom")
+		if err := classify(original); err != original {
+			t.Errorf("Expected unknown error to pass through, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Test hooks for the external repository_test package
+
+// NewTestDB returns a private database for t from the package harness
+func NewTestDB(t *testing.T) *sql.DB {
+	return harness.DB(t)
+}
+
+// NewTestRedis returns a private Redis database for t from the package harness
+func NewTestRedis(t *testing.T) *redis.Client {
+	return harness.Redis(t)
+}
@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserFilter describes an ad-hoc user search. Zero-valued fields are
+// ignored and the rest are combined with AND, so UserFilter{} matches
+// every user.
+type UserFilter struct {
+	// Email matches the address exactly
+	Email string
+	// EmailPrefix matches addresses starting with the prefix; LIKE
+	// wildcards in it are matched literally
+	EmailPrefix string
+	// NameLike is a case-insensitive ILIKE pattern such as "%smith%"
+	NameLike string
+	// CreatedAfter and CreatedBefore bound created_at as [after, before)
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// IDs restricts the search to the given ids; a non-nil empty slice
+	// matches nothing
+	IDs []int
+	// Sort picks the order and keyset used for pagination
+	Sort SortOrder
+	// WithTotal also counts every match, ignoring the page window
+	WithTotal bool
+	// IncludeDeleted also matches soft-deleted users
+	IncludeDeleted bool
+}
+
+// dialect holds the parts of a list query that differ between the
+// Postgres and SQLite stores
+type dialect struct {
+	// ilike returns a case-insensitive LIKE condition on column
+	ilike func(q *listQuery, column, pattern string) string
+	// hasPrefix returns a case-sensitive prefix match on column
+	hasPrefix func(q *listQuery, column, prefix string) string
+	// inInts returns a condition matching column against values
+	inInts func(q *listQuery, column string, values []int) string
+	// bind converts an argument before it reaches the driver, if set
+	bind func(v interface{}) interface{}
+}
+
+var postgresDialect = &dialect{
+	ilike: func(q *listQuery, column, pattern string) string {
+		return column + " ILIKE " + q.arg(pattern)
+	},
+	hasPrefix: func(q *listQuery, column, prefix string) string {
+		return column + " LIKE " + q.arg(escapeLike(prefix)+"%")
+	},
+	inInts: func(q *listQuery, column string, values []int) string {
+		return column + " = ANY(" + q.arg(pq.Array(values)) + ")"
+	},
+}
+
+// validate rejects filters that cannot be compiled or evaluated
+func (f UserFilter) validate() error {
+	if f.Sort < SortByID || f.Sort > SortByCreatedAtDesc {
+		return invalidInput("sort", f.Sort, "unknown sort order")
+	}
+	return nil
+}
+
+// compile turns the filter into a parameterized Postgres query
+func (f UserFilter) compile() (*listQuery, error) {
+	return f.compileFor(postgresDialect)
+}
+
+// compileFor turns the filter into a parameterized query for d. User input
+// only ever travels as $n arguments, never as SQL text.
+func (f UserFilter) compileFor(d *dialect) (*listQuery, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+
+	q := &listQuery{sort: f.Sort, dialect: d}
+	if !f.IncludeDeleted {
+		q.where = append(q.where, "deleted_at IS NULL")
+	}
+	if f.Email != "" {
+		q.where = append(q.where, "email = "+q.arg(f.Email))
+	}
+	if f.EmailPrefix != "" {
+		q.where = append(q.where, d.hasPrefix(q, "email", f.EmailPrefix))
+	}
+	if f.NameLike != "" {
+		q.where = append(q.where, d.ilike(q, "name", f.NameLike))
+	}
+	if !f.CreatedAfter.IsZero() {
+		q.where = append(q.where, "created_at >= "+q.arg(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		q.where = append(q.where, "created_at < "+q.arg(f.CreatedBefore))
+	}
+	if f.IDs != nil {
+		q.where = append(q.where, d.inInts(q, "id", f.IDs))
+	}
+
+	return q, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s using the default backslash
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Find returns one page of users matching filter. Page.Total is set only
+// when filter.WithTotal is true.
+func (r *UserRepository) Find(ctx context.Context, filter UserFilter, req PageRequest) (Page, error) {
+	filter.IncludeDeleted = filter.IncludeDeleted || r.includeDeleted
+	return find(ctx, r.db, postgresDialect, filter, req)
+}
+
+// find runs Find against db using the SQL of d
+func find(ctx context.Context, db DBExecutor, d *dialect, filter UserFilter, req PageRequest) (Page, error) {
+	q, err := filter.compileFor(d)
+	if err != nil {
+		return Page{}, err
+	}
+
+	var total *int
+	if filter.WithTotal {
+		// Count before the page window adds its keyset condition
+		query := "SELECT COUNT(*) FROM users" + q.whereSQL()
+
+		var count int
+		if err := db.QueryRowContext(ctx, query, q.args...).Scan(&count); err != nil {
+			return Page{}, fmt.Errorf("failed to count users: %w", classify(err))
+		}
+		total = &count
+	}
+
+	page, err := queryPage(ctx, db, q, req)
+	if err != nil {
+		return Page{}, err
+	}
+	page.Total = total
+
+	return page, nil
+}
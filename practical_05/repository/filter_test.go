@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserFilterCompile(t *testing.T) {
+	t.Run("Every Condition Is Parameterized", func(t *testing.T) {
+		filter := UserFilter{
+			Email:         "a@example.com",
+			EmailPrefix:   "a_",
+			NameLike:      "%'; DROP TABLE users; --%",
+			CreatedAfter:  time.Now().Add(-time.Hour),
+			CreatedBefore: time.Now(),
+			IDs:           []int{1, 2},
+		}
+
+		q, err := filter.compile()
+		if err != nil {
+			t.Fatalf("Failed to compile filter: %v", err)
+		}
+
+		where := strings.Join(q.where, " AND ")
+		if strings.Contains(where, "DROP") {
+			t.Fatalf("User input leaked into SQL: %s", where)
+		}
+
+		for i := 1; i <= 6; i++ {
+			if !strings.Contains(where, fmt.Sprintf("$%d", i)) {
+				t.Errorf("Expected placeholder $%d in: %s", i, where)
+			}
+		}
+
+		if len(q.args) != 6 {
+			t.Errorf("Expected 6 args, got: %d", len(q.args))
+		}
+
+		if q.args[1] != `a\_%` {
+			t.Errorf("Expected escaped prefix pattern, got: %v", q.args[1])
+		}
+	})
+
+	t.Run("Empty Filter Matches Every Live User", func(t *testing.T) {
+		q, err := UserFilter{}.compile()
+		if err != nil {
+			t.Fatalf("Failed to compile filter: %v", err)
+		}
+
+		if len(q.where) != 1 || q.where[0] != "deleted_at IS NULL" {
+			t.Errorf("Expected only the soft-delete condition, got: %v", q.where)
+		}
+
+		q, _ = UserFilter{IncludeDeleted: true}.compile()
+		if len(q.where) != 0 {
+			t.Errorf("Expected no conditions with IncludeDeleted, got: %v", q.where)
+		}
+	})
+
+	t.Run("Unknown Sort", func(t *testing.T) {
+		_, err := UserFilter{Sort: SortOrder(42)}.compile()
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput, got: %v", err)
+		}
+	})
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
+
+	var ids []int
+	var emails []string
+	for i := 1; i <= 3; i++ {
+		// Generated emails start with the name, so all share the prefix
+		user := f.Create(fixtures.User{Name: fmt.Sprintf("Finder %d", i)})
+		ids = append(ids, user.ID)
+		emails = append(emails, user.Email)
+	}
+
+	t.Run("Email Prefix With Total", func(t *testing.T) {
+		page, err := repo.Find(ctx, UserFilter{EmailPrefix: "finder", WithTotal: true}, PageRequest{Limit: 2})
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+
+		if len(page.Users) != 2 {
+			t.Errorf("Expected 2 users on the page, got: %d", len(page.Users))
+		}
+
+		if page.Total == nil || *page.Total != 3 {
+			t.Errorf("Expected total 3, got: %v", page.Total)
+		}
+	})
+
+	t.Run("Combined Conditions", func(t *testing.T) {
+		filter := UserFilter{
+			NameLike:     "finder%",
+			IDs:          []int{ids[0], ids[2]},
+			CreatedAfter: time.Now().Add(-time.Hour),
+			Sort:         SortByName,
+		}
+
+		page, err := repo.Find(ctx, filter, PageRequest{})
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+
+		if len(page.Users) != 2 || page.Users[0].Name != "Finder 1" || page.Users[1].Name != "Finder 3" {
+			t.Errorf("Expected Finder 1 and Finder 3, got: %+v", page.Users)
+		}
+
+		if page.Total != nil {
+			t.Error("Expected no total unless requested")
+		}
+	})
+
+	t.Run("Exact Email", func(t *testing.T) {
+		page, err := repo.Find(ctx, UserFilter{Email: emails[1]}, PageRequest{})
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+
+		if len(page.Users) != 1 || page.Users[0].ID != ids[1] {
+			t.Errorf("Expected only user %d, got: %+v", ids[1], page.Users)
+		}
+	})
+
+	t.Run("Wildcards In Prefix Are Literal", func(t *testing.T) {
+		page, err := repo.Find(ctx, UserFilter{EmailPrefix: "%"}, PageRequest{})
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+
+		if len(page.Users) != 0 {
+			t.Errorf("Expected no users for a literal %% prefix, got: %d", len(page.Users))
+		}
+	})
+}
@@ -0,0 +1,246 @@
+// Package fixtures builds test users that do not collide with each other
+// or with seeded rows. A Factory is tied to one test: its random names are
+// seeded from the test name, so a failing test sees the same data when it
+// is rerun, and every user it creates is deleted when the test ends.
+//
+//	f := fixtures.New(t, repo)
+//	user := f.Create(fixtures.User{Name: "Alice Smith"}) // email generated
+//
+//	set := f.Load("testdata/users.yaml")
+//	alice := set.User("alice")
+//
+// Fixture files are YAML or JSON, chosen by extension, and list users by
+// key. Fields left out are generated:
+//
+//	users:
+//	  alice:
+//	    name: Alice Smith
+//	  bob:
+//	    email: bob@example.com
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"practical5-example/models"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Store is the part of repository.UserStore fixtures need. Every backend
+// satisfies it.
+type Store interface {
+	Create(ctx context.Context, email, name string) (*models.User, error)
+	Delete(ctx context.Context, id int) error
+}
+
+// User describes a user to create. Empty fields are generated.
+type User struct {
+	Email string `json:"email" yaml:"email"`
+	Name  string `json:"name" yaml:"name"`
+}
+
+// file is the layout of a fixture set on disk
+type file struct {
+	Users map[string]User `json:"users" yaml:"users"`
+}
+
+var (
+	firstNames = []string{"Ada", "Alan", "Barbara", "Claude", "Donald", "Edsger", "Frances", "Grace", "John", "Ken", "Leslie", "Margaret", "Niklaus", "Radia", "Robin", "Tony"}
+	lastNames  = []string{"Allen", "Dijkstra", "Hamilton", "Hoare", "Hopper", "Knuth", "Lamport", "Liskov", "Lovelace", "McCarthy", "Milner", "Perlman", "Ritchie", "Shannon", "Thompson", "Wirth"}
+)
+
+// Factory creates users for one test
+type Factory struct {
+	t     testing.TB
+	store Store
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	// tag is derived from the test name and keeps emails from different
+	// tests apart when they share a store
+	tag string
+	seq int
+}
+
+// New returns a factory that creates users in store for t. Create fails
+// t, so subtests should make their own factory rather than share their
+// parent's.
+func New(t testing.TB, store Store) *Factory {
+	h := fnv.New64a()
+	h.Write([]byte(t.Name()))
+	seed := h.Sum64()
+
+	return &Factory{
+		t:     t,
+		store: store,
+		rng:   rand.New(rand.NewSource(int64(seed))),
+		tag:   fmt.Sprintf("%08x", uint32(seed)),
+	}
+}
+
+// Name returns a random full name
+func (f *Factory) Name() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.name()
+}
+
+func (f *Factory) name() string {
+	return firstNames[f.rng.Intn(len(firstNames))] + " " + lastNames[f.rng.Intn(len(lastNames))]
+}
+
+// Email returns an address unique within this factory and across tests
+// with different names. Two factories in one test produce the same
+// sequence, so a test must share one factory per store.
+func (f *Factory) Email() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.email(f.name())
+}
+
+// email builds an address from name plus the test tag and a sequence number
+func (f *Factory) email(name string) string {
+	f.seq++
+	local := strings.ToLower(strings.Join(strings.Fields(name), "."))
+	return fmt.Sprintf("%s.%s%d@example.test", local, f.tag, f.seq)
+}
+
+// fill generates the fields u leaves empty
+func (f *Factory) fill(u User) User {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if u.Name == "" {
+		u.Name = f.name()
+	}
+	if u.Email == "" {
+		u.Email = f.email(u.Name)
+	}
+	return u
+}
+
+// Create stores u, generating any empty fields, and deletes it when the
+// test ends. The test fails immediately if the store rejects the user.
+func (f *Factory) Create(u User) *models.User {
+	f.t.Helper()
+
+	u = f.fill(u)
+	user, err := f.store.Create(context.Background(), u.Email, u.Name)
+	if err != nil {
+		f.t.Fatalf("fixtures: failed to create user %s: %v", u.Email, err)
+	}
+
+	// The test may have deleted the user already, so errors are ignored
+	f.t.Cleanup(func() { f.store.Delete(context.Background(), user.ID) })
+	return user
+}
+
+// CreateN creates n generated users
+func (f *Factory) CreateN(n int) []*models.User {
+	f.t.Helper()
+
+	users := make([]*models.User, n)
+	for i := range users {
+		users[i] = f.Create(User{})
+	}
+	return users
+}
+
+// Set is the users created from one fixture file, by key
+type Set struct {
+	t     testing.TB
+	path  string
+	users map[string]*models.User
+}
+
+// Load creates the users in the fixture file at path
+func (f *Factory) Load(path string) *Set {
+	f.t.Helper()
+	return f.LoadFS(os.DirFS(filepath.Dir(path)), filepath.Base(path))
+}
+
+// LoadFS creates the users in the fixture file at path within fsys
+func (f *Factory) LoadFS(fsys fs.FS, path string) *Set {
+	f.t.Helper()
+
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		f.t.Fatalf("fixtures: failed to read %s: %v", path, err)
+	}
+
+	fx, err := parse(path, data)
+	if err != nil {
+		f.t.Fatalf("fixtures: failed to parse %s: %v", path, err)
+	}
+
+	// Keys are created in order so generated data and ids are repeatable
+	keys := make([]string, 0, len(fx.Users))
+	for key := range fx.Users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	set := &Set{t: f.t, path: path, users: make(map[string]*models.User, len(keys))}
+	for _, key := range keys {
+		set.users[key] = f.Create(fx.Users[key])
+	}
+	return set
+}
+
+// parse decodes a fixture file, rejecting unknown fields
+func parse(path string, data []byte) (file, error) {
+	var fx file
+
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&fx); err != nil {
+			return fx, err
+		}
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&fx); err != nil {
+			return fx, err
+		}
+	default:
+		return fx, fmt.Errorf("unsupported fixture format %q", ext)
+	}
+
+	return fx, nil
+}
+
+// User returns the user created for key. The test fails if the fixture
+// file has no such key.
+func (s *Set) User(key string) *models.User {
+	s.t.Helper()
+
+	user, ok := s.users[key]
+	if !ok {
+		s.t.Fatalf("fixtures: %s has no user %q", s.path, key)
+	}
+	return user
+}
+
+// Keys returns the fixture keys in sorted order
+func (s *Set) Keys() []string {
+	keys := make([]string, 0, len(s.users))
+	for key := range s.users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package fixtures_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"practical5-example/repository"
+	"practical5-example/repository/fixtures"
+)
+
+func TestFactory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Same Test Same Data", func(t *testing.T) {
+		a := fixtures.New(t, repository.NewMemoryUserStore())
+		b := fixtures.New(t, repository.NewMemoryUserStore())
+
+		for i := 0; i < 5; i++ {
+			if x, y := a.Email(), b.Email(); x != y {
+				t.Fatalf("Expected identical sequences, got %s and %s", x, y)
+			}
+		}
+	})
+
+	t.Run("Emails Are Unique", func(t *testing.T) {
+		f := fixtures.New(t, repository.NewMemoryUserStore())
+
+		seen := make(map[string]bool)
+		for _, user := range f.CreateN(50) {
+			if seen[user.Email] {
+				t.Fatalf("Duplicate email %s", user.Email)
+			}
+			seen[user.Email] = true
+		}
+
+		other := fixtures.New(&namedTB{TB: t, name: "Other"}, repository.NewMemoryUserStore())
+		if email := other.Email(); seen[email] {
+			t.Errorf("Expected another test's emails to differ, got %s", email)
+		}
+	})
+
+	t.Run("Cleanup Deletes Created Users", func(t *testing.T) {
+		store := repository.NewMemoryUserStore()
+
+		t.Run("Inner", func(t *testing.T) {
+			f := fixtures.New(t, store)
+			f.CreateN(3)
+			gone := f.Create(fixtures.User{})
+			store.Delete(ctx, gone.ID)
+		})
+
+		if count, _ := store.CountUsers(ctx); count != 0 {
+			t.Errorf("Expected users to be deleted after the test, got %d", count)
+		}
+	})
+
+	for _, path := range []string{"testdata/users.yaml", "testdata/users.json"} {
+		t.Run("Load "+path, func(t *testing.T) {
+			store := repository.NewMemoryUserStore()
+			set := fixtures.New(t, store).Load(path)
+
+			bob := set.User("bob")
+			if bob.Email != "bob@example.com" || bob.Name != "Bob Johnson" {
+				t.Errorf("Expected Bob as written, got: %+v", bob)
+			}
+
+			alice := set.User("alice")
+			if alice.Name != "Alice Smith" || !strings.HasPrefix(alice.Email, "alice.smith.") {
+				t.Errorf("Expected Alice with a generated email, got: %+v", alice)
+			}
+
+			if anon := set.User("anonymous"); anon.Name == "" || anon.Email == "" {
+				t.Errorf("Expected generated fields, got: %+v", anon)
+			}
+
+			stored, err := store.GetByID(ctx, bob.ID)
+			if err != nil || stored.Email != bob.Email {
+				t.Errorf("Expected Bob to be stored, got %+v (err=%v)", stored, err)
+			}
+
+			if keys := strings.Join(set.Keys(), ","); keys != "alice,anonymous,bob" {
+				t.Errorf("Expected sorted keys, got: %s", keys)
+			}
+		})
+	}
+}
+
+// namedTB reports a different test name, standing in for another test
+type namedTB struct {
+	testing.TB
+	name string
+}
+
+func (n *namedTB) Name() string { return n.name }
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"practical5-example/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// InvalidationChannel is the Redis pub/sub channel cached repositories
+// announce changed user ids on, prefixed by the CacheOptions namespace if
+// there is one. Each message is a space-separated list of ids; every
+// instance with an L1 cache drops those ids from it.
+const InvalidationChannel = "user:invalidate"
+
+// flushAll is the invalidation message that purges every L1 entirely
+const flushAll = "*"
+
+// lru is a bounded in-process cache of users with a per-entry TTL. The
+// least recently read entry is dropped when it is full.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front is most recently used
+	items map[int]*list.Element
+}
+
+type lruItem struct {
+	id      int
+	user    models.User
+	expires time.Time
+}
+
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{size: size, ttl: ttl, order: list.New(), items: make(map[int]*list.Element)}
+}
+
+// get returns a copy of the user cached for id
+func (c *lru) get(id int) (*models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[id]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.expires) {
+		c.order.Remove(el)
+		delete(c.items, id)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	user := item.user
+	return &user, true
+}
+
+// put caches a copy of user
+func (c *lru) put(user *models.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item := &lruItem{id: user.ID, user: *user, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.items[user.ID]; ok {
+		el.Value = item
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[user.ID] = c.order.PushFront(item)
+	for c.order.Len() > c.size && c.order.Len() > 0 {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).id)
+	}
+}
+
+func (c *lru) remove(ids ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if el, ok := c.items[id]; ok {
+			c.order.Remove(el)
+			delete(c.items, id)
+		}
+	}
+}
+
+func (c *lru) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.items)
+}
+
+// WithL1 puts a bounded in-process cache of up to size users in front of
+// Redis. Entries live for ttl, which should be well under the Redis TTL:
+// invalidations reach other instances over pub/sub, and ttl bounds how
+// stale an entry can get if a message is lost. Call it before the
+// repository is used, and Close the repository to stop listening. A size
+// or ttl that is not positive leaves the repository without an L1.
+func (r *CachedUserRepository) WithL1(size int, ttl time.Duration) *CachedUserRepository {
+	if size <= 0 || ttl <= 0 {
+		return r
+	}
+	r.l1 = newLRU(size, ttl)
+
+	pubsub := r.cache.Subscribe(context.Background(), r.keys.channel())
+	r.unsubscribe = pubsub.Close
+
+	r.listening.Add(1)
+	go func() {
+		defer r.listening.Done()
+		r.listen(pubsub)
+	}()
+
+	return r
+}
+
+// listen applies invalidation messages until the subscription is closed.
+// go-redis resubscribes after a dropped connection; messages sent while it
+// was down are lost, so every (re)subscription purges the whole L1.
+func (r *CachedUserRepository) listen(pubsub *redis.PubSub) {
+	ctx := context.Background()
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			if err == redis.ErrClosed {
+				return
+			}
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			r.l1.purge()
+		case *redis.Message:
+			if msg.Payload == flushAll {
+				r.l1.purge()
+				continue
+			}
+			r.l1.remove(parseIDs(msg.Payload)...)
+		}
+	}
+}
+
+// Close stops listening for invalidations. It is only needed with WithL1.
+func (r *CachedUserRepository) Close() error {
+	if r.unsubscribe == nil {
+		return nil
+	}
+	err := r.unsubscribe()
+	r.listening.Wait()
+	return err
+}
+
+// announce queues a message telling every instance to drop ids from its
+// L1, and drops them from this one's straight away
+func (r *CachedUserRepository) announce(ctx context.Context, pipe redis.Pipeliner, ids ...int) {
+	if len(ids) == 0 {
+		return
+	}
+	if r.l1 != nil {
+		r.l1.remove(ids...)
+	}
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	pipe.Publish(ctx, r.keys.channel(), strings.Join(parts, " "))
+}
+
+func parseIDs(payload string) []int {
+	fields := strings.Fields(payload)
+	ids := make([]int, 0, len(fields))
+	for _, f := range fields {
+		if id, err := strconv.Atoi(f); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
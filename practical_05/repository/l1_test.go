@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"practical5-example/models"
+	"practical5-example/repository/fixtures"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	t.Run("Evicts Least Recently Used", func(t *testing.T) {
+		c := newLRU(2, time.Minute)
+		c.put(&models.User{ID: 1})
+		c.put(&models.User{ID: 2})
+		c.get(1)
+		c.put(&models.User{ID: 3})
+
+		if _, ok := c.get(2); ok {
+			t.Error("Expected user 2 to be evicted")
+		}
+		if _, ok := c.get(1); !ok {
+			t.Error("Expected recently read user 1 to stay")
+		}
+	})
+
+	t.Run("Expires Entries", func(t *testing.T) {
+		c := newLRU(2, 10*time.Millisecond)
+		c.put(&models.User{ID: 1})
+		time.Sleep(20 * time.Millisecond)
+
+		if _, ok := c.get(1); ok {
+			t.Error("Expected entry to expire")
+		}
+		if len(c.items) != 0 {
+			t.Errorf("Expected expired entry to be dropped, %d remain", len(c.items))
+		}
+	})
+
+	t.Run("Holds Nothing Without Room", func(t *testing.T) {
+		for _, size := range []int{0, -1} {
+			c := newLRU(size, time.Minute)
+			c.put(&models.User{ID: 1})
+			c.put(&models.User{ID: 2})
+
+			if _, ok := c.get(1); ok {
+				t.Errorf("Expected size %d cache to hold nothing", size)
+			}
+			if len(c.items) != 0 || c.order.Len() != 0 {
+				t.Errorf("Expected size %d cache to be empty, %d remain", size, len(c.items))
+			}
+		}
+	})
+
+	t.Run("Returns Copies", func(t *testing.T) {
+		c := newLRU(1, time.Minute)
+		user := &models.User{ID: 1, Name: "Original"}
+		c.put(user)
+		user.Name = "Changed"
+
+		got, _ := c.get(1)
+		got.Name = "Changed Again"
+
+		if again, _ := c.get(1); again.Name != "Original" {
+			t.Errorf("Expected cached copy to be unchanged, got: %s", again.Name)
+		}
+	})
+}
+
+func TestCachedL1(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+
+	first := NewCachedUserStore(base, rdb).WithL1(100, time.Minute)
+	defer first.Close()
+	second := NewCachedUserStore(base, rdb).WithL1(100, time.Minute)
+	defer second.Close()
+
+	user := fixtures.New(t, base).Create(fixtures.User{})
+
+	t.Run("Serves Without Redis", func(t *testing.T) {
+		first.GetByIDCached(ctx, user.ID)
+		rdb.Del(ctx, first.keys.user(user.ID))
+
+		found, err := first.GetByIDCached(ctx, user.ID)
+		if err != nil || found.Email != user.Email {
+			t.Fatalf("Expected user from L1, got %+v (err=%v)", found, err)
+		}
+
+		if stats := first.Stats(); stats.L1Hits != 1 || stats.Loads != 1 {
+			t.Errorf("Expected 1 load then 1 L1 hit, got: %+v", stats)
+		}
+	})
+
+	t.Run("Invalidation Reaches Other Instances", func(t *testing.T) {
+		second.GetByIDCached(ctx, user.ID)
+
+		if err := first.UpdateCached(ctx, user.ID, user.Email, "Renamed Elsewhere"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		// Pub/sub is asynchronous, so give the message a moment to land
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			found, err := second.GetByIDCached(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+			if found.Name == "Renamed Elsewhere" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the other instance to drop its L1 entry, still got: %s", found.Name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("Writer Drops Its Own Entry", func(t *testing.T) {
+		first.GetByIDCached(ctx, user.ID)
+
+		if err := first.DeleteCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		if _, ok := first.l1.get(user.ID); ok {
+			t.Error("Expected the writer's L1 entry to be dropped immediately")
+		}
+	})
+}
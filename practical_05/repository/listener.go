@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserChangesChannel is the Postgres channel the users table triggers
+// notify on; see migrations/sql/0004_notify_user_changes.up.sql
+const UserChangesChannel = "user_changes"
+
+// Defaults used by NewChangeListener when ListenerOptions leaves a field zero
+const (
+	DefaultListenerMinReconnect = time.Second
+	DefaultListenerMaxReconnect = time.Minute
+	DefaultListenerPingInterval = 90 * time.Second
+)
+
+// UserChange is one notification from the users triggers. An email the
+// row did not have before or after the change is empty, and a TRUNCATE
+// has no id at all.
+type UserChange struct {
+	Op       string `json:"op"`
+	ID       int    `json:"id"`
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+// parseUserChange decodes a notification payload, reporting false if it
+// is not one the triggers send
+func parseUserChange(payload string) (UserChange, bool) {
+	var change UserChange
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return change, false
+	}
+	switch change.Op {
+	case "INSERT", "UPDATE", "DELETE":
+		return change, change.ID > 0
+	case "TRUNCATE":
+		return change, true
+	default:
+		return change, false
+	}
+}
+
+// ListenerOptions configures a ChangeListener
+type ListenerOptions struct {
+	// MinReconnect and MaxReconnect bound the exponential backoff between
+	// attempts to reconnect to Postgres
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+	// PingInterval is how long the listener waits without a notification
+	// before checking the connection is still alive
+	PingInterval time.Duration
+}
+
+// ListenerStats counts what a ChangeListener has done
+type ListenerStats struct {
+	// Notifications received from Postgres
+	Notifications int64
+	// Flushes of the whole cache namespace, on first connecting and after a
+	// reconnect, a TRUNCATE or a payload that could not be understood
+	Flushes int64
+	// Reconnects after the connection to Postgres was lost
+	Reconnects int64
+}
+
+// ChangeListener keeps a cached repository consistent with writes it did
+// not make itself, such as migrations, psql sessions or other services.
+// It LISTENs on UserChangesChannel and evicts each changed user and both
+// of its email keys. Notifications are delivered on commit, so evictions
+// never run ahead of the data.
+//
+// Notifications sent while the listener is disconnected are lost, so once
+// it first connects and after every reconnect the whole user cache
+// namespace is flushed. Writes
+// the repository made itself are announced too; evicting them again only
+// costs one extra miss.
+type ChangeListener struct {
+	repo     *CachedUserRepository
+	listener *pq.Listener
+	opts     ListenerOptions
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	notifications, flushes, reconnects atomic.Int64
+}
+
+// NewChangeListener returns a listener that evicts users from repo's cache
+// as notifications arrive on a connection opened with dsn. It does nothing
+// until Run is called.
+func NewChangeListener(repo *CachedUserRepository, dsn string, opts ListenerOptions) *ChangeListener {
+	if opts.MinReconnect <= 0 {
+		opts.MinReconnect = DefaultListenerMinReconnect
+	}
+	if opts.MaxReconnect <= 0 {
+		opts.MaxReconnect = DefaultListenerMaxReconnect
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = DefaultListenerPingInterval
+	}
+
+	l := &ChangeListener{repo: repo, opts: opts, ready: make(chan struct{})}
+	l.listener = pq.NewListener(dsn, opts.MinReconnect, opts.MaxReconnect, l.event)
+	return l
+}
+
+// event is called by pq.Listener from its own goroutine, so it only counts
+func (l *ChangeListener) event(event pq.ListenerEventType, err error) {
+	if event == pq.ListenerEventReconnected {
+		l.reconnects.Add(1)
+	}
+}
+
+// Run listens until ctx is done, then closes the connection. It returns
+// early only if Postgres rejects the LISTEN. A listener runs once.
+func (l *ChangeListener) Run(ctx context.Context) error {
+	defer func() {
+		l.listener.Close()
+		// pq closes Notify once its connection goroutine exits, which it
+		// cannot do while blocked sending a notification nobody reads
+		go func() {
+			for range l.listener.Notify {
+			}
+		}()
+	}()
+
+	// Listen blocks until the first connection succeeds, which may take
+	// several attempts, so it runs alongside the loop and can be abandoned
+	listening := make(chan error, 1)
+	go func() { listening <- l.listener.Listen(UserChangesChannel) }()
+
+	ping := time.NewTimer(l.opts.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-listening:
+			if err != nil {
+				return err
+			}
+			// Writes made before the listener started were never announced
+			l.flush(ctx)
+			l.readyOnce.Do(func() { close(l.ready) })
+
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return nil
+			}
+			// A nil notification follows a reconnect
+			if n == nil {
+				l.flush(ctx)
+			} else {
+				l.notifications.Add(1)
+				l.apply(ctx, n.Extra)
+			}
+			ping.Reset(l.opts.PingInterval)
+
+		case <-ping.C:
+			// A failed ping drops the connection, and pq reconnects
+			go l.listener.Ping()
+			ping.Reset(l.opts.PingInterval)
+		}
+	}
+}
+
+// Ready is closed once the listener is receiving notifications and has
+// flushed anything cached before it started
+func (l *ChangeListener) Ready() <-chan struct{} {
+	return l.ready
+}
+
+// Stats returns a snapshot of the listener counters
+func (l *ChangeListener) Stats() ListenerStats {
+	return ListenerStats{
+		Notifications: l.notifications.Load(),
+		Flushes:       l.flushes.Load(),
+		Reconnects:    l.reconnects.Load(),
+	}
+}
+
+// apply evicts the user a notification describes. Anything unexpected
+// flushes the namespace rather than risk leaving a stale entry.
+func (l *ChangeListener) apply(ctx context.Context, payload string) {
+	change, ok := parseUserChange(payload)
+	if !ok || change.Op == "TRUNCATE" {
+		l.flush(ctx)
+		return
+	}
+	l.repo.evict(ctx, change.ID, change.OldEmail, change.NewEmail)
+}
+
+func (l *ChangeListener) flush(ctx context.Context) {
+	l.flushes.Add(1)
+	l.repo.invalidateAll(ctx)
+}
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"practical5-example/repository/fixtures"
+	"testing"
+	"time"
+)
+
+func TestParseUserChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    UserChange
+		ok      bool
+	}{
+		{"Update", `{"op":"UPDATE","id":7,"old_email":"a@example.com","new_email":"b@example.com"}`, UserChange{"UPDATE", 7, "a@example.com", "b@example.com"}, true},
+		{"Insert", `{"op":"INSERT","id":8,"old_email":null,"new_email":"c@example.com"}`, UserChange{"INSERT", 8, "", "c@example.com"}, true},
+		{"Delete", `{"op":"DELETE","id":9,"old_email":"d@example.com","new_email":null}`, UserChange{"DELETE", 9, "d@example.com", ""}, true},
+		{"Truncate", `{"op":"TRUNCATE"}`, UserChange{Op: "TRUNCATE"}, true},
+		{"Missing ID", `{"op":"UPDATE"}`, UserChange{Op: "UPDATE"}, false},
+		{"Unknown Op", `{"op":"MERGE","id":1}`, UserChange{Op: "MERGE", ID: 1}, false},
+		{"Not JSON", `7`, UserChange{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseUserChange(tt.payload)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Expected %+v, %v, got %+v, %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+// eventually fails t unless cond holds within a few seconds
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChangeListener(t *testing.T) {
+	t.Parallel()
+	db, dsn := harness.DBWithDSN(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+	repo := NewCachedUserStore(base, rdb)
+
+	// Cached before the listener started, so no notification will evict it
+	rdb.Set(ctx, repo.keys.user(missingID), "stale", time.Minute)
+
+	listener := NewChangeListener(repo, dsn, ListenerOptions{MinReconnect: 10 * time.Millisecond, MaxReconnect: 100 * time.Millisecond})
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- listener.Run(runCtx) }()
+	t.Cleanup(func() {
+		stop()
+		if err := <-done; err != nil {
+			t.Errorf("Run failed: %v", err)
+		}
+	})
+
+	select {
+	case <-listener.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Listener never started listening")
+	}
+
+	cached := func(key string) bool {
+		exists, _ := rdb.Exists(ctx, key).Result()
+		return exists == 1
+	}
+
+	t.Run("Start Flushes Namespace", func(t *testing.T) {
+		if cached(repo.keys.user(missingID)) {
+			t.Error("Expected entries cached before the listener started to be flushed")
+		}
+		if flushes := listener.Stats().Flushes; flushes < 1 {
+			t.Errorf("Expected a flush on start, got: %d", flushes)
+		}
+	})
+
+	t.Run("External Update Evicts Both Emails", func(t *testing.T) {
+		f := fixtures.New(t, base)
+		user := f.Create(fixtures.User{})
+		repo.GetByEmailCached(ctx, user.Email)
+
+		_, err := db.ExecContext(ctx, "UPDATE users SET email = $1, name = $2 WHERE id = $3", f.Email(), "Changed In SQL", user.ID)
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		eventually(t, "Expected the external update to evict the user", func() bool {
+			return !cached(repo.keys.user(user.ID)) && !cached(repo.keys.email(user.Email))
+		})
+
+		found, _ := repo.GetByIDCached(ctx, user.ID)
+		if found.Name != "Changed In SQL" {
+			t.Errorf("Expected the updated name, got: %s", found.Name)
+		}
+	})
+
+	t.Run("External Delete Evicts", func(t *testing.T) {
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		repo.GetByIDCached(ctx, user.ID)
+
+		if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		eventually(t, "Expected the external delete to evict the user", func() bool {
+			return !cached(repo.keys.user(user.ID)) && !cached(repo.keys.email(user.Email))
+		})
+	})
+
+	t.Run("Rolled Back Writes Are Not Announced", func(t *testing.T) {
+		users := fixtures.New(t, base).CreateN(2)
+		rolledBack, committed := users[0], users[1]
+
+		// Notifications arrive in commit order, so once a committed update
+		// to one user has evicted it, every earlier notification (the
+		// inserts included) has been applied
+		touch := func() {
+			repo.GetByIDCached(ctx, committed.ID)
+			db.ExecContext(ctx, "UPDATE users SET name = name WHERE id = $1", committed.ID)
+			eventually(t, "Expected the committed update to evict its user", func() bool {
+				return !cached(repo.keys.user(committed.ID))
+			})
+		}
+		touch()
+
+		repo.GetByIDCached(ctx, rolledBack.ID)
+		tx, _ := db.BeginTx(ctx, nil)
+		tx.ExecContext(ctx, "UPDATE users SET name = 'Rolled Back' WHERE id = $1", rolledBack.ID)
+		tx.Rollback()
+		touch()
+
+		if !cached(repo.keys.user(rolledBack.ID)) {
+			t.Error("Expected the rolled back update to leave the user cached")
+		}
+	})
+
+	t.Run("Reconnect Flushes Namespace", func(t *testing.T) {
+		flushes := listener.Stats().Flushes
+		rdb.Set(ctx, repo.keys.user(999999), "stale", time.Minute)
+
+		_, err := db.ExecContext(ctx, `
+			SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+			WHERE datname = current_database() AND query ILIKE 'LISTEN%'`)
+		if err != nil {
+			t.Fatalf("Failed to terminate listener connection: %v", err)
+		}
+
+		eventually(t, "Expected the listener to reconnect and flush", func() bool {
+			stats := listener.Stats()
+			return stats.Reconnects > 0 && stats.Flushes > flushes && !cached(repo.keys.user(999999))
+		})
+	})
+}
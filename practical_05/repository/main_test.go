@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"os"
+	"practical5-example/internal/testharness"
+	"testing"
+)
+
+// harness gives every test its own database cloned from the migrated,
+// seeded template, and its own Redis database
+var harness = testharness.New(testharness.Options{Seed: "../migrations/testdata/seed.sql"})
+
+// missingID is an id no seeded or fixture user has, for not-found paths
+const missingID = 9999
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	harness.Close()
+	os.Exit(code)
+}
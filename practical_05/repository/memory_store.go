@@ -0,0 +1,552 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"iter"
+	"practical5-example/models"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryUserStore is a thread-safe UserStore that keeps users in a map.
+// It follows the same rules as the SQL stores (live emails are unique,
+// every write bumps version, soft-deleted users are hidden) and is meant
+// for unit tests and local tooling. Callers always receive copies.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]*models.User
+	nextID int
+}
+
+// NewMemoryUserStore creates an empty in-memory store
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[int]*models.User), nextID: 1}
+}
+
+// clone copies a stored user so callers cannot change the store
+func clone(user *models.User) *models.User {
+	c := *user
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+// liveByEmail returns the live user with email; the caller holds the lock
+func (s *MemoryUserStore) liveByEmail(email string) *models.User {
+	for _, user := range s.users {
+		if user.Email == email && user.DeletedAt == nil {
+			return user
+		}
+	}
+	return nil
+}
+
+// insert stores a new user; the caller holds the write lock
+func (s *MemoryUserStore) insert(email, name string) (*models.User, error) {
+	if s.liveByEmail(email) != nil {
+		return nil, &Error{Kind: ErrDuplicateEmail, Field: "email", Value: email}
+	}
+
+	user := &models.User{ID: s.nextID, Email: email, Name: name, CreatedAt: time.Now().UTC(), Version: 1}
+	s.users[user.ID] = user
+	s.nextID++
+	return user, nil
+}
+
+// live returns the live user with id; the caller holds the lock
+func (s *MemoryUserStore) live(id int) (*models.User, error) {
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, notFound("id", id)
+	}
+	return user, nil
+}
+
+// GetByID retrieves a user by ID
+func (s *MemoryUserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	if id <= 0 {
+		return nil, invalidInput("id", id, "must be positive")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, err := s.live(id)
+	if err != nil {
+		return nil, err
+	}
+	return clone(user), nil
+}
+
+// GetByEmail retrieves a user by email
+func (s *MemoryUserStore) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user := s.liveByEmail(email)
+	if user == nil {
+		return nil, notFound("email", email)
+	}
+	return clone(user), nil
+}
+
+// Create inserts a new user
+func (s *MemoryUserStore) Create(ctx context.Context, email, name string) (*models.User, error) {
+	if err := validateUser(email, name); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.insert(email, name)
+	if err != nil {
+		return nil, err
+	}
+	return clone(user), nil
+}
+
+// Update modifies an existing user
+func (s *MemoryUserStore) Update(ctx context.Context, id int, email, name string) error {
+	if err := validateUser(email, name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.live(id)
+	if err != nil {
+		return err
+	}
+	return s.update(user, email, name)
+}
+
+// update writes the fields and bumps version; the caller holds the lock
+func (s *MemoryUserStore) update(user *models.User, email, name string) error {
+	if other := s.liveByEmail(email); other != nil && other.ID != user.ID {
+		return &Error{Kind: ErrDuplicateEmail, Field: "email", Value: email}
+	}
+
+	user.Email = email
+	user.Name = name
+	user.Version++
+	return nil
+}
+
+// UpdateIfVersion modifies a user only if it is still at the given version
+func (s *MemoryUserStore) UpdateIfVersion(ctx context.Context, id, version int, email, name string) (*models.User, error) {
+	if err := validateUser(email, name); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.live(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Version != version {
+		return nil, &Error{
+			Kind:  ErrConflict,
+			Field: "version",
+			Value: user.Version,
+			Err:   fmt.Errorf("expected version %d", version),
+		}
+	}
+
+	if err := s.update(user, email, name); err != nil {
+		return nil, err
+	}
+	return clone(user), nil
+}
+
+// UpsertByEmail creates the user, or merges name into the existing user
+// with the same email
+func (s *MemoryUserStore) UpsertByEmail(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error) {
+	if _, _, err := prepareUpsert(email, name, policy); err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.liveByEmail(email)
+	if user == nil {
+		if strings.TrimSpace(name) == "" {
+			return nil, false, invalidInput("name", name, "must not be empty for a new user")
+		}
+
+		user, err := s.insert(email, name)
+		if err != nil {
+			return nil, false, err
+		}
+		return clone(user), true, nil
+	}
+
+	switch policy.Name {
+	case MergeOverwrite:
+		user.Name = name
+	case MergeCoalesce:
+		if strings.TrimSpace(name) != "" {
+			user.Name = name
+		}
+	}
+	user.Version++
+
+	return clone(user), false, nil
+}
+
+// Delete permanently removes a user
+func (s *MemoryUserStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return notFound("id", id)
+	}
+	delete(s.users, id)
+	return nil
+}
+
+// SoftDelete hides a user from every read path without removing the row
+func (s *MemoryUserStore) SoftDelete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.live(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	user.DeletedAt = &now
+	user.Version++
+	return nil
+}
+
+// Restore brings back a soft-deleted user. It fails with ErrDuplicateEmail
+// if a live user has taken the email in the meantime.
+func (s *MemoryUserStore) Restore(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt == nil {
+		return notFound("id", id)
+	}
+	if s.liveByEmail(user.Email) != nil {
+		return &Error{Kind: ErrDuplicateEmail, Field: "email", Value: user.Email}
+	}
+
+	user.DeletedAt = nil
+	user.Version++
+	return nil
+}
+
+// Purge permanently removes users that were soft-deleted more than
+// olderThan ago
+func (s *MemoryUserStore) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if olderThan < 0 {
+		return 0, invalidInput("older_than", olderThan, "must not be negative")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	var purged int64
+	for id, user := range s.users {
+		if user.DeletedAt != nil && user.DeletedAt.Before(cutoff) {
+			delete(s.users, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// List retrieves all users ordered by id
+func (s *MemoryUserStore) List(ctx context.Context) ([]models.User, error) {
+	return s.match(UserFilter{})
+}
+
+// FindByNamePattern finds users whose name matches an ILIKE pattern
+func (s *MemoryUserStore) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	return s.match(UserFilter{NameLike: pattern, Sort: SortByName})
+}
+
+// CountUsers returns total number of users
+func (s *MemoryUserStore) CountUsers(ctx context.Context) (int, error) {
+	users, err := s.match(UserFilter{})
+	return len(users), err
+}
+
+// GetRecentUsers returns users created in the last N days, newest first
+func (s *MemoryUserStore) GetRecentUsers(ctx context.Context, days int) ([]models.User, error) {
+	if days < 0 {
+		return nil, invalidInput("days", days, "must not be negative")
+	}
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	return s.match(UserFilter{CreatedAfter: since, Sort: SortByCreatedAtDesc})
+}
+
+// match returns copies of every user matching filter in filter.Sort order
+func (s *MemoryUserStore) match(filter UserFilter) ([]models.User, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	var nameLike *regexp.Regexp
+	if filter.NameLike != "" {
+		nameLike = likeRegexp(filter.NameLike)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var users []models.User
+	for _, user := range s.users {
+		switch {
+		case user.DeletedAt != nil && !filter.IncludeDeleted,
+			filter.Email != "" && user.Email != filter.Email,
+			filter.EmailPrefix != "" && !strings.HasPrefix(user.Email, filter.EmailPrefix),
+			nameLike != nil && !nameLike.MatchString(user.Name),
+			!filter.CreatedAfter.IsZero() && user.CreatedAt.Before(filter.CreatedAfter),
+			!filter.CreatedBefore.IsZero() && !user.CreatedAt.Before(filter.CreatedBefore),
+			filter.IDs != nil && !slices.Contains(filter.IDs, user.ID):
+			continue
+		}
+		users = append(users, *clone(user))
+	}
+
+	slices.SortFunc(users, func(a, b models.User) int { return compareUsers(filter.Sort, a, b) })
+	return users, nil
+}
+
+// likeRegexp translates a case-insensitive LIKE pattern with backslash
+// escapes into an anchored regular expression
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?is)^`)
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '%':
+			b.WriteString(`.*`)
+		case c == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.MustCompile(b.String())
+}
+
+// compareUsers orders users by the keyset of sort
+func compareUsers(sort SortOrder, a, b models.User) int {
+	switch sort {
+	case SortByName:
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	case SortByCreatedAtDesc:
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	default:
+		return cmp.Compare(a.ID, b.ID)
+	}
+}
+
+// Find returns one page of users matching filter
+func (s *MemoryUserStore) Find(ctx context.Context, filter UserFilter, req PageRequest) (Page, error) {
+	users, err := s.match(filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	limit, c, err := pageWindow(req, filter.Sort)
+	if err != nil {
+		return Page{}, err
+	}
+
+	// Walk away from the cursor in the direction of travel
+	window := users
+	if c != nil {
+		at := models.User{ID: c.ID, Name: c.Name, CreatedAt: c.CreatedAt}
+		window = nil
+		if c.Backward {
+			for i := len(users) - 1; i >= 0; i-- {
+				if compareUsers(filter.Sort, users[i], at) < 0 {
+					window = append(window, users[i])
+				}
+			}
+		} else {
+			for _, user := range users {
+				if compareUsers(filter.Sort, user, at) > 0 {
+					window = append(window, user)
+				}
+			}
+		}
+	}
+	if len(window) > limit+1 {
+		window = window[:limit+1]
+	}
+
+	page := assemblePage(window, limit, c, filter.Sort)
+	if filter.WithTotal {
+		total := len(users)
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// ListPage returns one page of users ordered by id
+func (s *MemoryUserStore) ListPage(ctx context.Context, req PageRequest) (Page, error) {
+	return s.Find(ctx, UserFilter{}, req)
+}
+
+// FindByNamePatternPage returns one page of users whose name matches the
+// ILIKE pattern, ordered by name
+func (s *MemoryUserStore) FindByNamePatternPage(ctx context.Context, pattern string, req PageRequest) (Page, error) {
+	return s.Find(ctx, UserFilter{NameLike: pattern, Sort: SortByName}, req)
+}
+
+// GetRecentUsersPage returns one page of users created in the last N days,
+// newest first
+func (s *MemoryUserStore) GetRecentUsersPage(ctx context.Context, days int, req PageRequest) (Page, error) {
+	if days < 0 {
+		return Page{}, invalidInput("days", days, "must not be negative")
+	}
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	return s.Find(ctx, UserFilter{CreatedAfter: since, Sort: SortByCreatedAtDesc}, req)
+}
+
+// Stream yields every user matching filter from a snapshot taken when
+// iteration starts. opts.BatchSize is ignored.
+func (s *MemoryUserStore) Stream(ctx context.Context, filter UserFilter, opts StreamOptions) iter.Seq2[models.User, error] {
+	return func(yield func(models.User, error) bool) {
+		users, err := s.match(filter)
+		if err != nil {
+			yield(models.User{}, err)
+			return
+		}
+
+		for _, user := range users {
+			if err := ctx.Err(); err != nil {
+				yield(models.User{}, err)
+				return
+			}
+			if !yield(user, nil) {
+				return
+			}
+		}
+	}
+}
+
+// BatchCreate creates multiple users atomically
+func (s *MemoryUserStore) BatchCreate(ctx context.Context, users []struct{ Email, Name string }) error {
+	_, err := s.BatchCreateIDs(ctx, users)
+	return err
+}
+
+// BatchCreateIDs behaves like BatchCreate and returns the new users' ids in
+// input order
+func (s *MemoryUserStore) BatchCreateIDs(ctx context.Context, users []struct{ Email, Name string }) ([]int, error) {
+	for _, user := range users {
+		if err := validateUser(user.Email, user.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, user := range users {
+		if seen[user.Email] || s.liveByEmail(user.Email) != nil {
+			return nil, &Error{Kind: ErrDuplicateEmail, Field: "email", Value: user.Email}
+		}
+		seen[user.Email] = true
+	}
+
+	ids := make([]int, 0, len(users))
+	for _, user := range users {
+		created, _ := s.insert(user.Email, user.Name)
+		ids = append(ids, created.ID)
+	}
+	return ids, nil
+}
+
+// BulkImport loads users atomically; the input is staged in memory first
+func (s *MemoryUserStore) BulkImport(ctx context.Context, users iter.Seq2[UserInput, error], opts BulkImportOptions) (BulkImportResult, error) {
+	rows, copied, err := stageImport(users, opts)
+	if err != nil {
+		return BulkImportResult{Copied: copied}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check everything before writing so a failure leaves no trace
+	if opts.OnDuplicate == DuplicateFail {
+		seen := make(map[string]bool)
+		for _, row := range rows {
+			if seen[row.Email] || s.liveByEmail(row.Email) != nil {
+				return BulkImportResult{Copied: copied}, &Error{Kind: ErrDuplicateEmail, Field: "email", Value: row.Email}
+			}
+			seen[row.Email] = true
+		}
+	}
+
+	result := BulkImportResult{Copied: copied}
+	for _, row := range rows {
+		user := s.liveByEmail(row.Email)
+		switch {
+		case user == nil:
+			user, _ = s.insert(row.Email, row.Name)
+		case opts.OnDuplicate == DuplicateUpsert:
+			user.Name = row.Name
+			user.Version++
+		default:
+			continue
+		}
+		result.IDs = append(result.IDs, user.ID)
+	}
+
+	result.Skipped = result.Copied - len(result.IDs)
+	return result, nil
+}
+
+// TransferUserData copies the source user's name onto the target user
+// atomically
+func (s *MemoryUserStore) TransferUserData(ctx context.Context, fromID, toID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, err := s.live(fromID)
+	if err != nil {
+		return fmt.Errorf("failed to get source user: %w", err)
+	}
+
+	to, err := s.live(toID)
+	if err != nil {
+		return err
+	}
+
+	to.Name = from.Name
+	to.Version++
+	return nil
+}
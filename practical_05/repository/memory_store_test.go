@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryUserStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Returns Copies", func(t *testing.T) {
+		store := NewMemoryUserStore()
+		created, _ := store.Create(ctx, "copy@example.com", "Copy")
+		created.Name = "Changed"
+
+		user, err := store.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if user.Name != "Copy" {
+			t.Errorf("Expected the stored user to be unchanged, got: %s", user.Name)
+		}
+	})
+
+	t.Run("Version Conflict", func(t *testing.T) {
+		store := NewMemoryUserStore()
+		user, _ := store.Create(ctx, "occ@example.com", "OCC")
+		store.Update(ctx, user.ID, "occ@example.com", "Newer")
+
+		_, err := store.UpdateIfVersion(ctx, user.ID, user.Version, "occ@example.com", "Stale")
+
+		var repoErr *Error
+		if !errors.As(err, &repoErr) || !errors.Is(err, ErrConflict) || repoErr.Value != 2 {
+			t.Errorf("Expected ErrConflict at version 2, got: %v", err)
+		}
+	})
+
+	t.Run("Name Pattern", func(t *testing.T) {
+		store := NewMemoryUserStore()
+		store.Create(ctx, "a@example.com", "Alice Smith")
+		store.Create(ctx, "b@example.com", "bob_smith")
+		store.Create(ctx, "c@example.com", "Carol")
+
+		users, err := store.FindByNamePattern(ctx, "%SMITH")
+		if err != nil || len(users) != 2 {
+			t.Fatalf("Expected 2 smiths, got %d (err=%v)", len(users), err)
+		}
+
+		users, _ = store.FindByNamePattern(ctx, `%\_%`)
+		if len(users) != 1 || users[0].Name != "bob_smith" {
+			t.Errorf("Expected an escaped underscore to match literally, got: %+v", users)
+		}
+	})
+
+	t.Run("Pages Both Ways", func(t *testing.T) {
+		store := NewMemoryUserStore()
+		for i := 1; i <= 5; i++ {
+			store.Create(ctx, fmt.Sprintf("p%d@example.com", i), fmt.Sprintf("P %d", i))
+		}
+
+		first, _ := store.ListPage(ctx, PageRequest{Limit: 2})
+		second, _ := store.ListPage(ctx, PageRequest{Limit: 2, Cursor: first.NextCursor})
+		back, err := store.ListPage(ctx, PageRequest{Limit: 2, Cursor: second.PrevCursor})
+		if err != nil {
+			t.Fatalf("Failed to page back: %v", err)
+		}
+
+		if fmt.Sprint(second.Users[0].ID, second.Users[1].ID) != "3 4" {
+			t.Errorf("Expected ids 3 4 on the second page, got: %+v", second.Users)
+		}
+		if len(back.Users) != 2 || back.Users[0].ID != 1 || back.PrevCursor != "" {
+			t.Errorf("Expected to return to the first page, got: %+v", back)
+		}
+	})
+
+	t.Run("Bulk Import Fails Atomically", func(t *testing.T) {
+		store := NewMemoryUserStore()
+		store.Create(ctx, "taken@example.com", "Taken")
+
+		_, err := store.BulkImport(ctx, inputs(
+			UserInput{Email: "fresh@example.com", Name: "Fresh"},
+			UserInput{Email: "taken@example.com", Name: "Clash"},
+		), BulkImportOptions{})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+
+		if count, _ := store.CountUsers(ctx); count != 1 {
+			t.Errorf("Expected nothing imported, got %d users", count)
+		}
+	})
+
+	t.Run("Concurrent Creates", func(t *testing.T) {
+		store := NewMemoryUserStore()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				store.Create(ctx, fmt.Sprintf("c%d@example.com", i), "Concurrent")
+			}(i)
+		}
+		wg.Wait()
+
+		if count, _ := store.CountUsers(ctx); count != 50 {
+			t.Errorf("Expected 50 users, got: %d", count)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"practical5-example/models"
+	"strings"
+	"time"
+)
+
+// Page size limits applied to PageRequest.Limit
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 1000
+)
+
+// SortOrder selects the keyset a page is ordered and paginated by
+type SortOrder int
+
+const (
+	// SortByID orders by id ascending
+	SortByID SortOrder = iota
+	// SortByName orders by name, then id, ascending
+	SortByName
+	// SortByCreatedAtDesc orders newest first by created_at, then id
+	SortByCreatedAtDesc
+)
+
+// PageRequest asks for one page of users
+type PageRequest struct {
+	// Limit is the page size; zero means DefaultPageLimit
+	Limit int
+	// Cursor is a NextCursor or PrevCursor from an earlier Page,
+	// or empty for the first page
+	Cursor string
+}
+
+// Page is one page of users. The cursors are opaque and are only valid
+// for the query that produced them.
+type Page struct {
+	Users      []models.User
+	NextCursor string
+	PrevCursor string
+	// Total counts every match across all pages when it was requested
+	Total *int
+}
+
+// cursor is the keyset position encoded into PageRequest.Cursor
+type cursor struct {
+	Sort      SortOrder `json:"s"`
+	Backward  bool      `json:"b,omitempty"`
+	ID        int       `json:"id"`
+	Name      string    `json:"n,omitempty"`
+	CreatedAt time.Time `json:"t,omitempty"`
+}
+
+func newCursor(sort SortOrder, backward bool, user models.User) string {
+	c := cursor{Sort: sort, Backward: backward, ID: user.ID}
+	switch sort {
+	case SortByName:
+		c.Name = user.Name
+	case SortByCreatedAtDesc:
+		c.CreatedAt = user.CreatedAt
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, sort SortOrder) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalidInput("cursor", s, "malformed cursor")
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, invalidInput("cursor", s, "malformed cursor")
+	}
+	if c.Sort != sort {
+		return nil, invalidInput("cursor", s, "cursor belongs to a different sort order")
+	}
+
+	return &c, nil
+}
+
+// listQuery accumulates the WHERE clause and $n arguments of a list query
+type listQuery struct {
+	where   []string
+	args    []interface{}
+	sort    SortOrder
+	dialect *dialect
+}
+
+// arg appends v to the arguments and returns its placeholder
+func (q *listQuery) arg(v interface{}) string {
+	if q.dialect != nil && q.dialect.bind != nil {
+		v = q.dialect.bind(v)
+	}
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// after restricts the query to rows past c in the direction of travel
+func (q *listQuery) after(c *cursor) {
+	// Paging backward flips the comparison; created_at sorts descending,
+	// so its forward comparison is already "less than"
+	op := ">"
+	if c.Backward != (q.sort == SortByCreatedAtDesc) {
+		op = "<"
+	}
+
+	switch q.sort {
+	case SortByID:
+		q.where = append(q.where, fmt.Sprintf("id %s %s", op, q.arg(c.ID)))
+	case SortByName:
+		q.where = append(q.where, fmt.Sprintf("(name, id) %s (%s, %s)", op, q.arg(c.Name), q.arg(c.ID)))
+	case SortByCreatedAtDesc:
+		q.where = append(q.where, fmt.Sprintf("(created_at, id) %s (%s, %s)", op, q.arg(c.CreatedAt), q.arg(c.ID)))
+	}
+}
+
+// orderBy returns the ORDER BY clause, reversed when paging backward
+func (q *listQuery) orderBy(backward bool) string {
+	var cols []string
+	switch q.sort {
+	case SortByName:
+		cols = []string{"name", "id"}
+	case SortByCreatedAtDesc:
+		cols = []string{"created_at", "id"}
+	default:
+		cols = []string{"id"}
+	}
+
+	dir := "ASC"
+	if backward != (q.sort == SortByCreatedAtDesc) {
+		dir = "DESC"
+	}
+
+	for i, col := range cols {
+		cols[i] = col + " " + dir
+	}
+	return strings.Join(cols, ", ")
+}
+
+// whereSQL returns the WHERE clause, or "" when there are no conditions
+func (q *listQuery) whereSQL() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// selectSQL returns the full SELECT statement for q without a LIMIT
+func (q *listQuery) selectSQL(backward bool) string {
+	return "SELECT " + userColumns + " FROM users" + q.whereSQL() + " ORDER BY " + q.orderBy(backward)
+}
+
+// queryPage runs q against db for the window described by req
+func queryPage(ctx context.Context, db DBExecutor, q *listQuery, req PageRequest) (Page, error) {
+	limit, c, err := pageWindow(req, q.sort)
+	if err != nil {
+		return Page{}, err
+	}
+	if c != nil {
+		q.after(c)
+	}
+
+	// Fetch one extra row to learn whether another page follows
+	query := fmt.Sprintf("%s LIMIT %s", q.selectSQL(c != nil && c.Backward), q.arg(limit+1))
+
+	rows, err := db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to list users: %w", classify(err))
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return Page{}, err
+	}
+
+	return assemblePage(users, limit, c, q.sort), nil
+}
+
+// pageWindow validates req and returns its page size and decoded cursor,
+// which is nil for the first page
+func pageWindow(req PageRequest, sort SortOrder) (int, *cursor, error) {
+	limit := req.Limit
+	if limit == 0 {
+		limit = DefaultPageLimit
+	}
+	if limit < 0 || limit > MaxPageLimit {
+		return 0, nil, invalidInput("limit", limit, fmt.Sprintf("must be between 1 and %d", MaxPageLimit))
+	}
+
+	if req.Cursor == "" {
+		return limit, nil, nil
+	}
+
+	c, err := decodeCursor(req.Cursor, sort)
+	if err != nil {
+		return 0, nil, err
+	}
+	return limit, c, nil
+}
+
+// assemblePage turns up to limit+1 rows read past c, in the direction of
+// travel, into a Page with its cursors
+func assemblePage(users []models.User, limit int, c *cursor, sort SortOrder) Page {
+	backward := c != nil && c.Backward
+
+	hasMore := len(users) > limit
+	if hasMore {
+		users = users[:limit]
+	}
+	if backward {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
+
+	page := Page{Users: users}
+	if len(users) == 0 {
+		return page
+	}
+
+	// Moving forward, a next page exists if we over-fetched and a previous
+	// page exists if we started from a cursor; backward mirrors that
+	if backward || hasMore {
+		page.NextCursor = newCursor(sort, false, users[len(users)-1])
+	}
+	if (backward && hasMore) || (!backward && c != nil) {
+		page.PrevCursor = newCursor(sort, true, users[0])
+	}
+
+	return page
+}
+
+// ListPage returns one page of users ordered by id
+func (r *UserRepository) ListPage(ctx context.Context, req PageRequest) (Page, error) {
+	return r.Find(ctx, UserFilter{}, req)
+}
+
+// FindByNamePatternPage returns one page of users whose name matches the
+// ILIKE pattern, ordered by name
+func (r *UserRepository) FindByNamePatternPage(ctx context.Context, pattern string, req PageRequest) (Page, error) {
+	return r.Find(ctx, UserFilter{NameLike: pattern, Sort: SortByName}, req)
+}
+
+// GetRecentUsersPage returns one page of users created in the last N days,
+// newest first
+func (r *UserRepository) GetRecentUsersPage(ctx context.Context, days int, req PageRequest) (Page, error) {
+	if days < 0 {
+		return Page{}, invalidInput("days", days, "must not be negative")
+	}
+
+	q := &listQuery{sort: SortByCreatedAtDesc}
+	q.where = append(q.where, r.scope(), "created_at >= NOW() - INTERVAL '1 day' * "+q.arg(days))
+	return queryPage(ctx, r.db, q, req)
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
+
+	for i := 1; i <= 5; i++ {
+		f.Create(fixtures.User{Name: fmt.Sprintf("Pager %d", i)})
+	}
+
+	t.Run("Forward And Backward By Name", func(t *testing.T) {
+		var pages []Page
+		req := PageRequest{Limit: 2}
+		for {
+			page, err := repo.FindByNamePatternPage(ctx, "Pager %", req)
+			if err != nil {
+				t.Fatalf("Failed to get page: %v", err)
+			}
+			pages = append(pages, page)
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+
+		if len(pages) != 3 {
+			t.Fatalf("Expected 3 pages, got: %d", len(pages))
+		}
+
+		if pages[0].PrevCursor != "" {
+			t.Error("Expected no previous cursor on the first page")
+		}
+
+		var names []string
+		for _, page := range pages {
+			for _, u := range page.Users {
+				names = append(names, u.Name)
+			}
+		}
+		for i, name := range names {
+			if want := fmt.Sprintf("Pager %d", i+1); name != want {
+				t.Errorf("Position %d: expected %s, got: %s", i, want, name)
+			}
+		}
+
+		// Stepping back from the last page returns the middle page again
+		back, err := repo.FindByNamePatternPage(ctx, "Pager %", PageRequest{Limit: 2, Cursor: pages[2].PrevCursor})
+		if err != nil {
+			t.Fatalf("Failed to get previous page: %v", err)
+		}
+
+		if len(back.Users) != 2 || back.Users[0].Name != "Pager 3" || back.Users[1].Name != "Pager 4" {
+			t.Errorf("Expected Pager 3 and Pager 4, got: %+v", back.Users)
+		}
+
+		if back.NextCursor == "" || back.PrevCursor == "" {
+			t.Error("Expected both cursors on a middle page")
+		}
+	})
+
+	t.Run("List Pages Cover Every User", func(t *testing.T) {
+		total, _ := repo.CountUsers(ctx)
+
+		seen := 0
+		lastID := 0
+		req := PageRequest{Limit: 3}
+		for {
+			page, err := repo.ListPage(ctx, req)
+			if err != nil {
+				t.Fatalf("Failed to list page: %v", err)
+			}
+			for _, u := range page.Users {
+				if u.ID <= lastID {
+					t.Fatalf("Expected ascending ids, got %d after %d", u.ID, lastID)
+				}
+				lastID = u.ID
+				seen++
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+
+		if seen != total {
+			t.Errorf("Expected %d users across pages, got: %d", total, seen)
+		}
+	})
+
+	t.Run("Recent Users Newest First", func(t *testing.T) {
+		page, err := repo.GetRecentUsersPage(ctx, 1, PageRequest{Limit: 2})
+		if err != nil {
+			t.Fatalf("Failed to get recent users page: %v", err)
+		}
+
+		if len(page.Users) != 2 {
+			t.Fatalf("Expected 2 users, got: %d", len(page.Users))
+		}
+
+		if page.Users[0].CreatedAt.Before(page.Users[1].CreatedAt) {
+			t.Error("Expected newest user first")
+		}
+
+		next, err := repo.GetRecentUsersPage(ctx, 1, PageRequest{Limit: 2, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatalf("Failed to get next page: %v", err)
+		}
+
+		for _, u := range next.Users {
+			if u.ID == page.Users[0].ID || u.ID == page.Users[1].ID {
+				t.Errorf("User %d repeated across pages", u.ID)
+			}
+		}
+	})
+
+	t.Run("Invalid Requests", func(t *testing.T) {
+		if _, err := repo.ListPage(ctx, PageRequest{Limit: MaxPageLimit + 1}); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for oversized limit, got: %v", err)
+		}
+
+		if _, err := repo.ListPage(ctx, PageRequest{Cursor: "not-a-cursor"}); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for malformed cursor, got: %v", err)
+		}
+
+		page, _ := repo.ListPage(ctx, PageRequest{Limit: 1})
+		_, err := repo.FindByNamePatternPage(ctx, "%", PageRequest{Cursor: page.NextCursor})
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for cursor from another sort, got: %v", err)
+		}
+	})
+}
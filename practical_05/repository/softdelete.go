@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SoftDelete hides a user from every read path without removing the row.
+// The email becomes free for a new user straight away.
+func (r *UserRepository) SoftDelete(ctx context.Context, id int) error {
+	query := "UPDATE users SET deleted_at = NOW(), version = version + 1 WHERE id = $1 AND deleted_at IS NULL"
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to soft delete user: %w", classify(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return notFound("id", id)
+	}
+
+	return nil
+}
+
+// Restore brings back a soft-deleted user. It fails with ErrDuplicateEmail
+// if a live user has taken the email in the meantime.
+func (r *UserRepository) Restore(ctx context.Context, id int) error {
+	query := "UPDATE users SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL"
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore user: %w", classify(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return notFound("id", id)
+	}
+
+	return nil
+}
+
+// Purge permanently removes users that were soft-deleted more than
+// olderThan ago and returns how many rows it removed
+func (r *UserRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if olderThan < 0 {
+		return 0, invalidInput("older_than", olderThan, "must not be negative")
+	}
+
+	query := "DELETE FROM users WHERE deleted_at < NOW() - make_interval(secs => $1)"
+
+	result, err := r.db.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", classify(err))
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return purged, nil
+}
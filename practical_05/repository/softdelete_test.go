@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"practical5-example/repository/fixtures"
+	"testing"
+	"time"
+)
+
+func TestSoftDelete(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	t.Run("Hidden From Reads", func(t *testing.T) {
+		user, _ := repo.Create(ctx, fixtures.New(t, repo).Email(), "Soft Deleted")
+		defer repo.Delete(ctx, user.ID)
+
+		countBefore, _ := repo.CountUsers(ctx)
+
+		if err := repo.SoftDelete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to soft delete user: %v", err)
+		}
+
+		if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected GetByID to hide deleted user, got: %v", err)
+		}
+
+		if _, err := repo.GetByEmail(ctx, user.Email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected GetByEmail to hide deleted user, got: %v", err)
+		}
+
+		countAfter, _ := repo.CountUsers(ctx)
+		if countAfter != countBefore-1 {
+			t.Errorf("Expected count %d, got: %d", countBefore-1, countAfter)
+		}
+
+		users, _ := repo.FindByNamePattern(ctx, "Soft Deleted")
+		if len(users) != 0 {
+			t.Errorf("Expected FindByNamePattern to hide deleted user, got: %d", len(users))
+		}
+
+		recent, _ := repo.GetRecentUsers(ctx, 1)
+		for _, u := range recent {
+			if u.ID == user.ID {
+				t.Error("Expected GetRecentUsers to hide deleted user")
+			}
+		}
+
+		if err := repo.Update(ctx, user.ID, user.Email, "Edited"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected Update to ignore deleted user, got: %v", err)
+		}
+	})
+
+	t.Run("Include Deleted", func(t *testing.T) {
+		user, _ := repo.Create(ctx, fixtures.New(t, repo).Email(), "Include Deleted")
+		defer repo.Delete(ctx, user.ID)
+		repo.SoftDelete(ctx, user.ID)
+
+		deleted, err := repo.IncludeDeleted().GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Expected IncludeDeleted to find user: %v", err)
+		}
+
+		if deleted.DeletedAt == nil {
+			t.Error("Expected DeletedAt to be set")
+		}
+
+		page, err := repo.Find(ctx, UserFilter{Email: user.Email, IncludeDeleted: true}, PageRequest{})
+		if err != nil || len(page.Users) != 1 {
+			t.Errorf("Expected filter with IncludeDeleted to find user, got: %v %v", page.Users, err)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		user, _ := repo.Create(ctx, fixtures.New(t, repo).Email(), "Restore Me")
+		defer repo.Delete(ctx, user.ID)
+		repo.SoftDelete(ctx, user.ID)
+
+		if err := repo.Restore(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to restore user: %v", err)
+		}
+
+		restored, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Expected restored user to be visible: %v", err)
+		}
+
+		if restored.DeletedAt != nil {
+			t.Error("Expected DeletedAt to be cleared")
+		}
+
+		if err := repo.Restore(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected restoring a live user to fail, got: %v", err)
+		}
+	})
+
+	t.Run("Email Reuse And Restore Conflict", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		old, _ := repo.Create(ctx, email, "Old Owner")
+		defer repo.Delete(ctx, old.ID)
+		repo.SoftDelete(ctx, old.ID)
+
+		// The partial unique index only covers live rows
+		current, err := repo.Create(ctx, email, "New Owner")
+		if err != nil {
+			t.Fatalf("Expected email of deleted user to be reusable: %v", err)
+		}
+		defer repo.Delete(ctx, current.ID)
+
+		if err := repo.Restore(ctx, old.ID); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail on restore, got: %v", err)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		user, _ := repo.Create(ctx, fixtures.New(t, repo).Email(), "Purge Me")
+		defer repo.Delete(ctx, user.ID)
+		repo.SoftDelete(ctx, user.ID)
+
+		purged, err := repo.Purge(ctx, time.Hour)
+		if err != nil {
+			t.Fatalf("Failed to purge: %v", err)
+		}
+
+		if _, err := repo.IncludeDeleted().GetByID(ctx, user.ID); err != nil {
+			t.Errorf("Expected recent deletion to survive a 1h purge (purged %d): %v", purged, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		if _, err := repo.Purge(ctx, time.Millisecond); err != nil {
+			t.Fatalf("Failed to purge: %v", err)
+		}
+
+		if _, err := repo.IncludeDeleted().GetByID(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected user to be purged, got: %v", err)
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		if err := repo.SoftDelete(ctx, missingID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got: %v", err)
+		}
+	})
+}
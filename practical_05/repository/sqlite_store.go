@@ -0,0 +1,360 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"practical5-example/models"
+	"strings"
+	"time"
+)
+
+// sqliteSchema mirrors the Postgres schema built by the migrations package.
+// created_at is stored as fixed-width UTC text so it sorts and compares
+// correctly as a string.
+const sqliteSchema = `
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		name TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+		version INTEGER NOT NULL DEFAULT 1,
+		deleted_at TIMESTAMP
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_key ON users (email) WHERE deleted_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_users_name ON users (name, id);
+	CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at, id);
+	CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+`
+
+// sqliteTimeLayout matches strftime('%Y-%m-%d %H:%M:%f') in UTC
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+var sqliteDialect = &dialect{
+	// SQLite's LIKE is already case-insensitive for ASCII, but has no
+	// default escape character
+	ilike: func(q *listQuery, column, pattern string) string {
+		return column + " LIKE " + q.arg(pattern) + ` ESCAPE '\'`
+	},
+	hasPrefix: func(q *listQuery, column, prefix string) string {
+		p := q.arg(prefix)
+		return fmt.Sprintf("substr(%s, 1, length(%s)) = %s", column, p, p)
+	},
+	inInts: func(q *listQuery, column string, values []int) string {
+		if len(values) == 0 {
+			return "FALSE"
+		}
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = q.arg(v)
+		}
+		return column + " IN (" + strings.Join(placeholders, ", ") + ")"
+	},
+	bind: func(v interface{}) interface{} {
+		if t, ok := v.(time.Time); ok {
+			return sqliteTime(t)
+		}
+		return v
+	},
+}
+
+// SQLiteUserStore is a UserStore backed by SQLite, for tools and tests
+// that should run without a Postgres server. Statements that are portable
+// are shared with UserRepository; the rest are rewritten for SQLite.
+type SQLiteUserStore struct {
+	db   *sql.DB
+	repo *UserRepository
+}
+
+// NewSQLiteUserStore creates the users schema in db if needed and returns
+// a store on top of it. db is typically opened with the pure-Go
+// modernc.org/sqlite driver:
+//
+//	db, err := sql.Open("sqlite", "file:users.db?_pragma=busy_timeout(5000)")
+//
+// Every connection to ":memory:" gets its own empty database, so limit
+// in-memory databases to one connection with db.SetMaxOpenConns(1).
+func NewSQLiteUserStore(ctx context.Context, db *sql.DB) (*SQLiteUserStore, error) {
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return nil, fmt.Errorf("failed to create schema: %w", classify(err))
+	}
+
+	return &SQLiteUserStore{db: db, repo: NewUserRepository(db)}, nil
+}
+
+// GetByID retrieves a user by ID
+func (s *SQLiteUserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// GetByEmail retrieves a user by email
+func (s *SQLiteUserStore) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.repo.GetByEmail(ctx, email)
+}
+
+// Create inserts a new user
+func (s *SQLiteUserStore) Create(ctx context.Context, email, name string) (*models.User, error) {
+	return s.repo.Create(ctx, email, name)
+}
+
+// Update modifies an existing user
+func (s *SQLiteUserStore) Update(ctx context.Context, id int, email, name string) error {
+	return s.repo.Update(ctx, id, email, name)
+}
+
+// UpdateIfVersion modifies a user only if it is still at the given version
+func (s *SQLiteUserStore) UpdateIfVersion(ctx context.Context, id, version int, email, name string) (*models.User, error) {
+	return s.repo.UpdateIfVersion(ctx, id, version, email, name)
+}
+
+// UpsertByEmail creates the user, or merges name into the existing user
+// with the same email
+func (s *SQLiteUserStore) UpsertByEmail(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error) {
+	nameExpr, hasName, err := prepareUpsert(email, name, policy)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// SQLite has no xmax, but every update bumps version past 1
+	if hasName {
+		return upsertUser(ctx, s.db, "(version = 1)", email, name, nameExpr)
+	}
+
+	var user *models.User
+	err = s.repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		var inserted bool
+		user, inserted, err = upsertUser(ctx, txRepo.db, "(version = 1)", email, name, nameExpr)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			return invalidInput("name", name, "must not be empty for a new user")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, false, nil
+}
+
+// Delete permanently removes a user
+func (s *SQLiteUserStore) Delete(ctx context.Context, id int) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// SoftDelete hides a user from every read path without removing the row
+func (s *SQLiteUserStore) SoftDelete(ctx context.Context, id int) error {
+	query := "UPDATE users SET deleted_at = $1, version = version + 1 WHERE id = $2 AND deleted_at IS NULL"
+
+	result, err := s.db.ExecContext(ctx, query, sqliteTime(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to soft delete user: %w", classify(err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return notFound("id", id)
+	}
+
+	return nil
+}
+
+// Restore brings back a soft-deleted user
+func (s *SQLiteUserStore) Restore(ctx context.Context, id int) error {
+	return s.repo.Restore(ctx, id)
+}
+
+// Purge permanently removes users that were soft-deleted more than
+// olderThan ago
+func (s *SQLiteUserStore) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	if olderThan < 0 {
+		return 0, invalidInput("older_than", olderThan, "must not be negative")
+	}
+
+	query := "DELETE FROM users WHERE deleted_at < $1"
+
+	result, err := s.db.ExecContext(ctx, query, sqliteTime(time.Now().Add(-olderThan)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %w", classify(err))
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return purged, nil
+}
+
+// List retrieves all users
+func (s *SQLiteUserStore) List(ctx context.Context) ([]models.User, error) {
+	return s.repo.List(ctx)
+}
+
+// FindByNamePattern finds users whose name matches a LIKE pattern,
+// ignoring ASCII case
+func (s *SQLiteUserStore) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	query := "SELECT " + userColumns + ` FROM users WHERE name LIKE $1 ESCAPE '\' AND deleted_at IS NULL ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find users by pattern: %w", classify(err))
+	}
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+// CountUsers returns total number of users
+func (s *SQLiteUserStore) CountUsers(ctx context.Context) (int, error) {
+	return s.repo.CountUsers(ctx)
+}
+
+// GetRecentUsers returns users created in the last N days
+func (s *SQLiteUserStore) GetRecentUsers(ctx context.Context, days int) ([]models.User, error) {
+	if days < 0 {
+		return nil, invalidInput("days", days, "must not be negative")
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE created_at >= $1 AND deleted_at IS NULL ORDER BY created_at DESC"
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	rows, err := s.db.QueryContext(ctx, query, sqliteTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent users: %w", classify(err))
+	}
+	defer rows.Close()
+
+	return scanUsers(rows)
+}
+
+// Find returns one page of users matching filter
+func (s *SQLiteUserStore) Find(ctx context.Context, filter UserFilter, req PageRequest) (Page, error) {
+	return find(ctx, s.db, sqliteDialect, filter, req)
+}
+
+// ListPage returns one page of users ordered by id
+func (s *SQLiteUserStore) ListPage(ctx context.Context, req PageRequest) (Page, error) {
+	return s.Find(ctx, UserFilter{}, req)
+}
+
+// FindByNamePatternPage returns one page of users whose name matches the
+// pattern, ordered by name
+func (s *SQLiteUserStore) FindByNamePatternPage(ctx context.Context, pattern string, req PageRequest) (Page, error) {
+	return s.Find(ctx, UserFilter{NameLike: pattern, Sort: SortByName}, req)
+}
+
+// GetRecentUsersPage returns one page of users created in the last N days,
+// newest first
+func (s *SQLiteUserStore) GetRecentUsersPage(ctx context.Context, days int, req PageRequest) (Page, error) {
+	if days < 0 {
+		return Page{}, invalidInput("days", days, "must not be negative")
+	}
+
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	return s.Find(ctx, UserFilter{CreatedAfter: since, Sort: SortByCreatedAtDesc}, req)
+}
+
+// Stream yields every user matching filter. SQLite reads rows lazily
+// already, so opts.BatchSize is ignored.
+func (s *SQLiteUserStore) Stream(ctx context.Context, filter UserFilter, opts StreamOptions) iter.Seq2[models.User, error] {
+	return func(yield func(models.User, error) bool) {
+		q, err := filter.compileFor(sqliteDialect)
+		if err == nil {
+			err = streamRows(ctx, s.db, q, yield)
+		}
+
+		if err != nil && !errors.Is(err, errStopStream) {
+			yield(models.User{}, err)
+		}
+	}
+}
+
+// BatchCreate creates multiple users in a transaction
+func (s *SQLiteUserStore) BatchCreate(ctx context.Context, users []struct{ Email, Name string }) error {
+	return s.repo.BatchCreate(ctx, users)
+}
+
+// BatchCreateIDs behaves like BatchCreate and returns the new users' ids in
+// input order
+func (s *SQLiteUserStore) BatchCreateIDs(ctx context.Context, users []struct{ Email, Name string }) ([]int, error) {
+	return s.repo.BatchCreateIDs(ctx, users)
+}
+
+// BulkImport loads users in one transaction. SQLite has no COPY, so the
+// input is staged in memory and inserted row by row.
+func (s *SQLiteUserStore) BulkImport(ctx context.Context, users iter.Seq2[UserInput, error], opts BulkImportOptions) (BulkImportResult, error) {
+	rows, copied, err := stageImport(users, opts)
+	if err != nil {
+		return BulkImportResult{Copied: copied}, err
+	}
+
+	result := BulkImportResult{Copied: copied}
+	err = s.repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		for _, row := range rows {
+			var user *models.User
+			var err error
+
+			switch opts.OnDuplicate {
+			case DuplicateFail:
+				user, err = txRepo.Create(ctx, row.Email, row.Name)
+			case DuplicateSkip:
+				user, err = insertIfAbsent(ctx, txRepo.db, row)
+			case DuplicateUpsert:
+				user, _, err = upsertUser(ctx, txRepo.db, "(version = 1)", row.Email, row.Name, "EXCLUDED.name")
+			}
+			if err != nil {
+				return err
+			}
+
+			if user != nil {
+				result.IDs = append(result.IDs, user.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return BulkImportResult{Copied: copied}, err
+	}
+
+	result.Skipped = result.Copied - len(result.IDs)
+	return result, nil
+}
+
+// insertIfAbsent inserts row unless its email is taken, returning nil then
+func insertIfAbsent(ctx context.Context, db DBExecutor, row UserInput) (*models.User, error) {
+	query := `
+		INSERT INTO users (email, name)
+		VALUES ($1, $2)
+		ON CONFLICT (email) WHERE deleted_at IS NULL DO NOTHING
+		RETURNING ` + userColumns
+
+	var user models.User
+	err := scanUser(db.QueryRowContext(ctx, query, row.Email, row.Name), &user)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to import user: %w", classify(err))
+	}
+
+	return &user, nil
+}
+
+// TransferUserData copies the source user's name onto the target user
+// atomically
+func (s *SQLiteUserStore) TransferUserData(ctx context.Context, fromID, toID int) error {
+	return s.repo.TransferUserData(ctx, fromID, toID)
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestSQLiteStore opens a private in-memory SQLite store
+func newTestSQLiteStore(t *testing.T) *SQLiteUserStore {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := NewSQLiteUserStore(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	return store
+}
+
+func TestSQLiteUserStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Create And Read Back", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+
+		created, err := store.Create(ctx, "lite@example.com", "Lite")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		if created.Version != 1 || created.CreatedAt.IsZero() {
+			t.Errorf("Expected version 1 and a creation time, got: %+v", created)
+		}
+
+		user, err := store.GetByEmail(ctx, "lite@example.com")
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if user.ID != created.ID || !user.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("Expected %+v, got: %+v", created, user)
+		}
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		store.Create(ctx, "dup@example.com", "First")
+
+		_, err := store.Create(ctx, "dup@example.com", "Second")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+	})
+
+	t.Run("Upsert Reports Inserts", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+
+		_, inserted, err := store.UpsertByEmail(ctx, "up@example.com", "First", UpsertPolicy{})
+		if err != nil || !inserted {
+			t.Fatalf("Expected insert, got inserted=%v err=%v", inserted, err)
+		}
+
+		user, inserted, err := store.UpsertByEmail(ctx, "up@example.com", "", UpsertPolicy{Name: MergeCoalesce})
+		if err != nil || inserted {
+			t.Fatalf("Expected update, got inserted=%v err=%v", inserted, err)
+		}
+
+		if user.Name != "First" || user.Version != 2 {
+			t.Errorf("Expected coalesced name at version 2, got: %+v", user)
+		}
+	})
+
+	t.Run("Soft Delete Frees Email", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		user, _ := store.Create(ctx, "gone@example.com", "Gone")
+
+		if err := store.SoftDelete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to soft delete user: %v", err)
+		}
+
+		if _, err := store.GetByID(ctx, user.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got: %v", err)
+		}
+
+		if _, err := store.Create(ctx, "gone@example.com", "Again"); err != nil {
+			t.Fatalf("Expected email to be reusable: %v", err)
+		}
+
+		if err := store.Restore(ctx, user.ID); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail on restore, got: %v", err)
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		purged, err := store.Purge(ctx, 0)
+		if err != nil || purged != 1 {
+			t.Errorf("Expected 1 purged user, got %d (err=%v)", purged, err)
+		}
+	})
+
+	t.Run("Pages Newest First", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		for i := 1; i <= 5; i++ {
+			store.Create(ctx, fmt.Sprintf("page%d@example.com", i), fmt.Sprintf("Page %d", i))
+			time.Sleep(2 * time.Millisecond)
+		}
+
+		var ids []int
+		req := PageRequest{Limit: 2}
+		for {
+			page, err := store.GetRecentUsersPage(ctx, 1, req)
+			if err != nil {
+				t.Fatalf("Failed to get page: %v", err)
+			}
+			for _, u := range page.Users {
+				ids = append(ids, u.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			req.Cursor = page.NextCursor
+		}
+
+		if fmt.Sprint(ids) != "[5 4 3 2 1]" {
+			t.Errorf("Expected ids [5 4 3 2 1], got: %v", ids)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		store.Create(ctx, "Ann@example.com", "Ann")
+		store.Create(ctx, "ann@example.com", "ANNA")
+		store.Create(ctx, "bob@example.com", "Bob")
+
+		page, err := store.Find(ctx, UserFilter{EmailPrefix: "ann", NameLike: "ann%", WithTotal: true}, PageRequest{})
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+
+		if *page.Total != 1 || page.Users[0].Name != "ANNA" {
+			t.Errorf("Expected only ANNA, got: %+v", page.Users)
+		}
+
+		page, err = store.Find(ctx, UserFilter{IDs: []int{}}, PageRequest{})
+		if err != nil || len(page.Users) != 0 {
+			t.Errorf("Expected an empty ID list to match nothing, got %d users (err=%v)", len(page.Users), err)
+		}
+	})
+
+	t.Run("Bulk Import Upserts", func(t *testing.T) {
+		store := newTestSQLiteStore(t)
+		store.Create(ctx, "old@example.com", "Old")
+
+		result, err := store.BulkImport(ctx, inputs(
+			UserInput{Email: "old@example.com", Name: "Renamed"},
+			UserInput{Email: "new@example.com", Name: "First"},
+			UserInput{Email: "new@example.com", Name: "Last"},
+		), BulkImportOptions{OnDuplicate: DuplicateUpsert})
+		if err != nil {
+			t.Fatalf("Failed to import users: %v", err)
+		}
+
+		if result.Copied != 3 || len(result.IDs) != 2 || result.Skipped != 1 {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+
+		user, _ := store.GetByEmail(ctx, "new@example.com")
+		if user == nil || user.Name != "Last" {
+			t.Errorf("Expected the last occurrence to win, got: %+v", user)
+		}
+	})
+}
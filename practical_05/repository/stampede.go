@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"practical5-example/models"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultEarlyRefreshBeta is the XFetch beta new cached repositories use.
+// Values above 1 refresh earlier, 0 disables early refresh.
+const DefaultEarlyRefreshBeta = 1.0
+
+// CacheEntry is what a Codec stores under a user's key. Delta is how long
+// the store took to load the user, and with Expires it decides when a hit
+// is refreshed early. Entries written after a mutation have no Delta and
+// are never refreshed early.
+type CacheEntry struct {
+	User    *models.User  `json:"user"`
+	Delta   time.Duration `json:"delta,omitempty"`
+	Expires time.Time     `json:"expires"`
+}
+
+// refreshEarly reports whether a hit should reload the entry now. This is
+// XFetch: each reader refreshes with a probability that rises as expiry
+// nears, scaled by how slow the load is, so one reader usually reloads a
+// hot key before it expires and the rest never miss.
+func (e *CacheEntry) refreshEarly(beta float64) bool {
+	if beta <= 0 || e.Delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(e.Delta) * beta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(gap).Before(e.Expires)
+}
+
+// CacheStats counts how GetByIDCached requests were served
+type CacheStats struct {
+	// L1Hits were served from the in-process cache without touching Redis
+	L1Hits int64
+	// Hits were served from Redis
+	Hits int64
+	// NegativeHits were answered ErrNotFound from a cached tombstone
+	NegativeHits int64
+	// Misses found nothing usable in Redis
+	Misses int64
+	// Loads are store queries made to fill the cache
+	Loads int64
+	// Coalesced requests waited on another request's load in this
+	// process instead of querying the store
+	Coalesced int64
+	// LockWaits were filled by another instance holding the load lock
+	LockWaits int64
+	// EarlyRefreshes reloaded an entry before it expired
+	EarlyRefreshes int64
+	// Bypassed counts Redis calls skipped because the circuit was open
+	Bypassed int64
+	// Corrupt entries could not be decoded and were deleted
+	Corrupt int64
+	// Incompatible entries were written by another codec or envelope
+	// version and were deleted
+	Incompatible int64
+}
+
+type cacheCounters struct {
+	l1Hits, hits, negativeHits, misses, loads, coalesced, lockWaits, earlyRefreshes, bypassed, corrupt, incompatible atomic.Int64
+}
+
+// Stats returns a snapshot of the cache counters
+func (r *CachedUserRepository) Stats() CacheStats {
+	return CacheStats{
+		L1Hits:         r.stats.l1Hits.Load(),
+		Hits:           r.stats.hits.Load(),
+		NegativeHits:   r.stats.negativeHits.Load(),
+		Misses:         r.stats.misses.Load(),
+		Loads:          r.stats.loads.Load(),
+		Coalesced:      r.stats.coalesced.Load(),
+		LockWaits:      r.stats.lockWaits.Load(),
+		EarlyRefreshes: r.stats.earlyRefreshes.Load(),
+		Bypassed:       r.stats.bypassed.Load(),
+		Corrupt:        r.stats.corrupt.Load(),
+		Incompatible:   r.stats.incompatible.Load(),
+	}
+}
+
+// WithLoadLock makes a miss take a short Redis lock before querying the
+// store, so only one instance loads a key at a time; the others wait up to
+// ttl for it to appear in Redis. Zero disables the lock. Call it before
+// the repository is used.
+func (r *CachedUserRepository) WithLoadLock(ttl time.Duration) *CachedUserRepository {
+	r.lockTTL = ttl
+	return r
+}
+
+// WithEarlyRefresh sets the XFetch beta. Zero disables early refresh.
+// Call it before the repository is used.
+func (r *CachedUserRepository) WithEarlyRefresh(beta float64) *CachedUserRepository {
+	r.beta = beta
+	return r
+}
+
+// loadShared loads id once per process however many requests miss on it
+// together. The load runs detached from any one caller's context, so a
+// cancelled request does not fail the others waiting on it.
+func (r *CachedUserRepository) loadShared(ctx context.Context, id int) (*models.User, error) {
+	leader := false
+	ch := r.flight.DoChan(r.keys.user(id), func() (any, error) {
+		leader = true
+		return r.load(context.WithoutCancel(ctx), id)
+	})
+
+	select {
+	case res := <-ch:
+		if !leader {
+			r.stats.coalesced.Add(1)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*models.User), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load fills the user's entry from the store, under the load lock if enabled
+func (r *CachedUserRepository) load(ctx context.Context, id int) (*models.User, error) {
+	key := r.keys.user(id)
+
+	if r.lockTTL > 0 {
+		token, locked := r.lock(ctx, key)
+		if locked {
+			defer r.unlock(ctx, key, token)
+		} else if entry := r.awaitFill(ctx, key); entry != nil {
+			r.stats.lockWaits.Add(1)
+			return entry.User, nil
+		}
+	}
+
+	r.stats.loads.Add(1)
+	start := time.Now()
+	user, err := r.store.GetByID(ctx, id)
+	if err != nil {
+		r.bury(ctx, id, err)
+		return nil, err
+	}
+
+	r.writeUser(ctx, user, time.Since(start), false)
+	return user, nil
+}
+
+// compareAndDelete deletes a key only if it still holds the given value.
+// It releases load locks, so a loader that outlived its lock cannot
+// release someone else's, and discards undecodable entries without
+// deleting one written since.
+var compareAndDelete = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// lock tries to take the load lock for key. A Redis error counts as not
+// locked without waiting, since nobody else can be holding it either.
+func (r *CachedUserRepository) lock(ctx context.Context, key string) (string, bool) {
+	token := strconv.FormatUint(rand.Uint64(), 36)
+	var ok bool
+	err := r.guard(ctx, func() (err error) {
+		ok, err = r.cache.SetNX(ctx, r.keys.lock(key), token, r.lockTTL).Result()
+		return err
+	})
+	if err != nil {
+		return "", false
+	}
+	return token, ok
+}
+
+func (r *CachedUserRepository) unlock(ctx context.Context, key, token string) {
+	r.guard(ctx, func() error {
+		return compareAndDelete.Run(ctx, r.cache, []string{r.keys.lock(key)}, token).Err()
+	})
+}
+
+// awaitFill polls for another instance to fill key, giving up after the
+// lock TTL. It returns nil if the key never appears or the lock was not
+// taken because Redis failed.
+func (r *CachedUserRepository) awaitFill(ctx context.Context, key string) *CacheEntry {
+	interval := max(r.lockTTL/20, 5*time.Millisecond)
+	deadline := time.Now().Add(r.lockTTL)
+
+	for {
+		if entry := r.cached(ctx, key); entry != nil {
+			return entry
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		var exists int64
+		err := r.guard(ctx, func() (err error) {
+			exists, err = r.cache.Exists(ctx, r.keys.lock(key)).Result()
+			return err
+		})
+		if err != nil || exists == 0 {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"context"
+	"practical5-example/models"
+	"practical5-example/repository/fixtures"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowStore delays GetByID so concurrent misses overlap, and counts calls
+type slowStore struct {
+	UserStore
+	delay time.Duration
+	calls atomic.Int64
+}
+
+func (s *slowStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	s.calls.Add(1)
+	time.Sleep(s.delay)
+	return s.UserStore.GetByID(ctx, id)
+}
+
+// readTogether calls GetByIDCached n times at once across repos in turn
+func readTogether(t *testing.T, n, id int, repos ...*CachedUserRepository) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(repo *CachedUserRepository) {
+			defer wg.Done()
+			if _, err := repo.GetByIDCached(context.Background(), id); err != nil {
+				errs <- err
+			}
+		}(repos[i%len(repos)])
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Failed to get user: %v", err)
+	}
+}
+
+func TestCacheStampede(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+
+	t.Run("Concurrent Misses Coalesce", func(t *testing.T) {
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		store := &slowStore{UserStore: base, delay: 100 * time.Millisecond}
+		repo := NewCachedUserStore(store, rdb)
+
+		readTogether(t, 20, user.ID, repo)
+
+		if calls := store.calls.Load(); calls != 1 {
+			t.Errorf("Expected 1 store query, got: %d", calls)
+		}
+
+		stats := repo.Stats()
+		if stats.Loads != 1 || stats.Coalesced != 19 {
+			t.Errorf("Expected 1 load and 19 coalesced requests, got: %+v", stats)
+		}
+	})
+
+	t.Run("Load Lock Spans Instances", func(t *testing.T) {
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		store := &slowStore{UserStore: base, delay: 100 * time.Millisecond}
+		first := NewCachedUserStore(store, rdb).WithLoadLock(time.Second)
+		second := NewCachedUserStore(store, rdb).WithLoadLock(time.Second)
+
+		readTogether(t, 2, user.ID, first, second)
+
+		if calls := store.calls.Load(); calls != 1 {
+			t.Errorf("Expected 1 store query across instances, got: %d", calls)
+		}
+
+		if waits := first.Stats().LockWaits + second.Stats().LockWaits; waits != 1 {
+			t.Errorf("Expected one instance to wait on the lock, got %d waits", waits)
+		}
+
+		if exists, _ := rdb.Exists(ctx, first.keys.lock(first.keys.user(user.ID))).Result(); exists != 0 {
+			t.Error("Expected the load lock to be released")
+		}
+	})
+
+	t.Run("Hot Key Refreshes Early", func(t *testing.T) {
+		user := fixtures.New(t, base).Create(fixtures.User{})
+		store := &slowStore{UserStore: base, delay: 10 * time.Millisecond}
+
+		// A huge beta makes every hit on a loaded entry refresh
+		repo := NewCachedUserStore(store, rdb).WithEarlyRefresh(1e9)
+		repo.GetByIDCached(ctx, user.ID)
+		repo.GetByIDCached(ctx, user.ID)
+
+		if stats := repo.Stats(); stats.EarlyRefreshes != 1 || store.calls.Load() != 2 {
+			t.Errorf("Expected the hit to refresh early, got %+v after %d queries", stats, store.calls.Load())
+		}
+
+		repo.WithEarlyRefresh(0)
+		repo.GetByIDCached(ctx, user.ID)
+
+		if calls := store.calls.Load(); calls != 2 {
+			t.Errorf("Expected no refresh with beta 0, got %d queries", calls)
+		}
+	})
+
+	t.Run("Written Entries Are Not Refreshed Early", func(t *testing.T) {
+		repo := NewCachedUserStore(base, rdb).WithEarlyRefresh(1e9)
+		user, err := repo.CreateCached(ctx, fixtures.New(t, base).Email(), "Written")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		defer repo.DeleteCached(ctx, user.ID)
+
+		repo.GetByIDCached(ctx, user.ID)
+
+		if stats := repo.Stats(); stats.Hits != 1 || stats.EarlyRefreshes != 0 {
+			t.Errorf("Expected a plain hit, got: %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"iter"
+	"practical5-example/models"
+	"time"
+)
+
+// UserStore is the storage contract shared by every user backend. It is
+// satisfied by the Postgres UserRepository, the SQLite SQLiteUserStore and
+// the in-memory MemoryUserStore, and all of them report failures with the
+// same *Error kinds. CachedUserRepository implements it too, so caching can
+// be layered over any of them.
+//
+// Transactions are backend specific and are not part of the contract; use
+// UserRepository.RunInTx directly when Postgres transactions are needed, or
+// CachedUserRepository.RunInTx to keep the cache in step with them.
+type UserStore interface {
+	GetByID(ctx context.Context, id int) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	Create(ctx context.Context, email, name string) (*models.User, error)
+	Update(ctx context.Context, id int, email, name string) error
+	UpdateIfVersion(ctx context.Context, id, version int, email, name string) (*models.User, error)
+	UpsertByEmail(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error)
+	Delete(ctx context.Context, id int) error
+	SoftDelete(ctx context.Context, id int) error
+	Restore(ctx context.Context, id int) error
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+
+	List(ctx context.Context) ([]models.User, error)
+	FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	GetRecentUsers(ctx context.Context, days int) ([]models.User, error)
+
+	Find(ctx context.Context, filter UserFilter, req PageRequest) (Page, error)
+	ListPage(ctx context.Context, req PageRequest) (Page, error)
+	FindByNamePatternPage(ctx context.Context, pattern string, req PageRequest) (Page, error)
+	GetRecentUsersPage(ctx context.Context, days int, req PageRequest) (Page, error)
+	Stream(ctx context.Context, filter UserFilter, opts StreamOptions) iter.Seq2[models.User, error]
+
+	BatchCreate(ctx context.Context, users []struct{ Email, Name string }) error
+	BulkImport(ctx context.Context, users iter.Seq2[UserInput, error], opts BulkImportOptions) (BulkImportResult, error)
+	TransferUserData(ctx context.Context, fromID, toID int) error
+}
+
+var (
+	_ UserStore = (*UserRepository)(nil)
+	_ UserStore = (*SQLiteUserStore)(nil)
+	_ UserStore = (*MemoryUserStore)(nil)
+	_ UserStore = (*CachedUserRepository)(nil)
+)
@@ -0,0 +1,462 @@
+// Package storetest is a conformance suite for repository.UserStore
+// implementations. A backend passes when
+//
+//	storetest.Run(t, func(t *testing.T) repository.UserStore { ... })
+//
+// succeeds. The suite only touches users it creates, removes them when each
+// subtest ends and measures counts as differences, so it can run against a
+// shared database that already holds other rows.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/models"
+	"practical5-example/repository"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Factory returns the store under test. It is called once per subtest and
+// may return a fresh store or the same shared one every time.
+type Factory func(t *testing.T) repository.UserStore
+
+// missingID is an id no backend under test will have assigned
+const missingID = 1 << 30
+
+// runSeq keeps emails unique across runs against the same database
+var runSeq atomic.Int64
+
+// Run checks newStore's stores against the UserStore contract
+func Run(t *testing.T, newStore Factory) {
+	t.Run("GetByID", func(t *testing.T) { testGetByID(newSuite(t, newStore)) })
+	t.Run("GetByEmail", func(t *testing.T) { testGetByEmail(newSuite(t, newStore)) })
+	t.Run("Create", func(t *testing.T) { testCreate(newSuite(t, newStore)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(newSuite(t, newStore)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(newSuite(t, newStore)) })
+	t.Run("List", func(t *testing.T) { testList(newSuite(t, newStore)) })
+	t.Run("FindByNamePattern", func(t *testing.T) { testFindByNamePattern(newSuite(t, newStore)) })
+	t.Run("CountUsers", func(t *testing.T) { testCountUsers(newSuite(t, newStore)) })
+	t.Run("GetRecentUsers", func(t *testing.T) { testGetRecentUsers(newSuite(t, newStore)) })
+	t.Run("BatchCreate", func(t *testing.T) { testBatchCreate(newSuite(t, newStore)) })
+	t.Run("TransferUserData", func(t *testing.T) { testTransferUserData(newSuite(t, newStore)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(newSuite(t, newStore)) })
+}
+
+// suite bundles the store with helpers for one subtest
+type suite struct {
+	*testing.T
+	ctx   context.Context
+	store repository.UserStore
+	tag   string
+	seq   atomic.Int64
+}
+
+func newSuite(t *testing.T, newStore Factory) *suite {
+	return &suite{
+		T:     t,
+		ctx:   context.Background(),
+		store: newStore(t),
+		tag:   fmt.Sprintf("%d-%d", time.Now().UnixNano(), runSeq.Add(1)),
+	}
+}
+
+// email returns an address no other subtest or run uses
+func (s *suite) email() string {
+	return fmt.Sprintf("storetest-%s-%d@example.com", s.tag, s.seq.Add(1))
+}
+
+// create adds a user that is deleted again when the subtest ends
+func (s *suite) create(name string) *models.User {
+	s.Helper()
+
+	user, err := s.store.Create(s.ctx, s.email(), name)
+	if err != nil {
+		s.Fatalf("Failed to create user: %v", err)
+	}
+	s.cleanup(user.ID)
+	return user
+}
+
+// cleanup deletes id when the subtest ends
+func (s *suite) cleanup(id int) {
+	s.Cleanup(func() { s.store.Delete(context.Background(), id) })
+}
+
+// expectKind fails unless err is a *repository.Error of the given kind
+func (s *suite) expectKind(err error, kind error) *repository.Error {
+	s.Helper()
+
+	var repoErr *repository.Error
+	if !errors.Is(err, kind) || !errors.As(err, &repoErr) {
+		s.Errorf("Expected *repository.Error of kind %v, got: %v", kind, err)
+		return nil
+	}
+	return repoErr
+}
+
+// contains reports whether users holds id
+func contains(users []models.User, id int) bool {
+	for _, u := range users {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func testGetByID(s *suite) {
+	created := s.create("Get By ID")
+
+	user, err := s.store.GetByID(s.ctx, created.ID)
+	if err != nil {
+		s.Fatalf("Failed to get user: %v", err)
+	}
+
+	if user.Email != created.Email || user.Name != created.Name || user.Version != created.Version {
+		s.Errorf("Expected %+v, got: %+v", created, user)
+	}
+
+	if !user.CreatedAt.Equal(created.CreatedAt) {
+		s.Errorf("Expected created_at %v, got: %v", created.CreatedAt, user.CreatedAt)
+	}
+
+	_, err = s.store.GetByID(s.ctx, missingID)
+	if repoErr := s.expectKind(err, repository.ErrNotFound); repoErr != nil && repoErr.Field != "id" {
+		s.Errorf("Expected field id, got: %s", repoErr.Field)
+	}
+
+	_, err = s.store.GetByID(s.ctx, 0)
+	s.expectKind(err, repository.ErrInvalidInput)
+}
+
+func testGetByEmail(s *suite) {
+	created := s.create("Get By Email")
+
+	user, err := s.store.GetByEmail(s.ctx, created.Email)
+	if err != nil {
+		s.Fatalf("Failed to get user: %v", err)
+	}
+
+	if user.ID != created.ID {
+		s.Errorf("Expected id %d, got: %d", created.ID, user.ID)
+	}
+
+	_, err = s.store.GetByEmail(s.ctx, s.email())
+	s.expectKind(err, repository.ErrNotFound)
+}
+
+func testCreate(s *suite) {
+	user := s.create("Created User")
+
+	if user.ID <= 0 || user.Version != 1 || user.CreatedAt.IsZero() || user.DeletedAt != nil {
+		s.Errorf("Expected a live user at version 1 with an id and created_at, got: %+v", user)
+	}
+
+	_, err := s.store.Create(s.ctx, user.Email, "Duplicate")
+	s.expectKind(err, repository.ErrDuplicateEmail)
+
+	_, err = s.store.Create(s.ctx, "not-an-email", "Invalid")
+	s.expectKind(err, repository.ErrInvalidInput)
+
+	_, err = s.store.Create(s.ctx, s.email(), " ")
+	s.expectKind(err, repository.ErrInvalidInput)
+}
+
+func testUpdate(s *suite) {
+	user := s.create("Before Update")
+	email := s.email()
+
+	if err := s.store.Update(s.ctx, user.ID, email, "After Update"); err != nil {
+		s.Fatalf("Failed to update user: %v", err)
+	}
+
+	updated, err := s.store.GetByID(s.ctx, user.ID)
+	if err != nil {
+		s.Fatalf("Failed to get user: %v", err)
+	}
+
+	if updated.Email != email || updated.Name != "After Update" {
+		s.Errorf("Expected %s / After Update, got: %s / %s", email, updated.Email, updated.Name)
+	}
+
+	if updated.Version != user.Version+1 {
+		s.Errorf("Expected version %d, got: %d", user.Version+1, updated.Version)
+	}
+
+	other := s.create("Other")
+	err = s.store.Update(s.ctx, user.ID, other.Email, "Clash")
+	s.expectKind(err, repository.ErrDuplicateEmail)
+
+	err = s.store.Update(s.ctx, missingID, s.email(), "Nobody")
+	s.expectKind(err, repository.ErrNotFound)
+
+	err = s.store.Update(s.ctx, user.ID, "", "No Email")
+	s.expectKind(err, repository.ErrInvalidInput)
+}
+
+func testDelete(s *suite) {
+	user := s.create("Deleted User")
+
+	if err := s.store.Delete(s.ctx, user.ID); err != nil {
+		s.Fatalf("Failed to delete user: %v", err)
+	}
+
+	_, err := s.store.GetByID(s.ctx, user.ID)
+	s.expectKind(err, repository.ErrNotFound)
+
+	err = s.store.Delete(s.ctx, user.ID)
+	s.expectKind(err, repository.ErrNotFound)
+
+	// The email is free again once the user is gone
+	again, err := s.store.Create(s.ctx, user.Email, "Recreated")
+	if err != nil {
+		s.Fatalf("Expected email to be reusable: %v", err)
+	}
+	s.cleanup(again.ID)
+}
+
+func testList(s *suite) {
+	first := s.create("List One")
+	second := s.create("List Two")
+
+	users, err := s.store.List(s.ctx)
+	if err != nil {
+		s.Fatalf("Failed to list users: %v", err)
+	}
+
+	if !contains(users, first.ID) || !contains(users, second.ID) {
+		s.Errorf("Expected users %d and %d in list", first.ID, second.ID)
+	}
+
+	for i := 1; i < len(users); i++ {
+		if users[i-1].ID >= users[i].ID {
+			s.Fatalf("Expected list ordered by id, got %d before %d", users[i-1].ID, users[i].ID)
+		}
+	}
+}
+
+func testFindByNamePattern(s *suite) {
+	name := "Pattern " + s.tag
+	a := s.create(name + " Beta")
+	b := s.create(name + " alpha")
+	s.create("Unrelated " + s.tag)
+
+	users, err := s.store.FindByNamePattern(s.ctx, "%"+s.tag+" %")
+	if err != nil {
+		s.Fatalf("Failed to find users: %v", err)
+	}
+
+	if len(users) != 2 || !contains(users, a.ID) || !contains(users, b.ID) {
+		s.Fatalf("Expected users %d and %d, got: %+v", a.ID, b.ID, users)
+	}
+
+	// Matching ignores case
+	users, err = s.store.FindByNamePattern(s.ctx, "PATTERN "+s.tag+" ALPHA")
+	if err != nil {
+		s.Fatalf("Failed to find users: %v", err)
+	}
+
+	if len(users) != 1 || users[0].ID != b.ID {
+		s.Errorf("Expected case-insensitive match on user %d, got: %+v", b.ID, users)
+	}
+
+	users, err = s.store.FindByNamePattern(s.ctx, "nothing-"+s.tag)
+	if err != nil || len(users) != 0 {
+		s.Errorf("Expected no matches, got %d (err=%v)", len(users), err)
+	}
+}
+
+func testCountUsers(s *suite) {
+	before, err := s.store.CountUsers(s.ctx)
+	if err != nil {
+		s.Fatalf("Failed to count users: %v", err)
+	}
+
+	s.create("Counted One")
+	s.create("Counted Two")
+
+	after, err := s.store.CountUsers(s.ctx)
+	if err != nil {
+		s.Fatalf("Failed to count users: %v", err)
+	}
+
+	if after != before+2 {
+		s.Errorf("Expected count %d, got: %d", before+2, after)
+	}
+}
+
+func testGetRecentUsers(s *suite) {
+	user := s.create("Recent User")
+
+	users, err := s.store.GetRecentUsers(s.ctx, 1)
+	if err != nil {
+		s.Fatalf("Failed to get recent users: %v", err)
+	}
+
+	if !contains(users, user.ID) {
+		s.Errorf("Expected user %d among recent users", user.ID)
+	}
+
+	for i := 1; i < len(users); i++ {
+		if users[i-1].CreatedAt.Before(users[i].CreatedAt) {
+			s.Fatalf("Expected newest first, got %v before %v", users[i-1].CreatedAt, users[i].CreatedAt)
+		}
+	}
+
+	_, err = s.store.GetRecentUsers(s.ctx, -1)
+	s.expectKind(err, repository.ErrInvalidInput)
+}
+
+func testBatchCreate(s *suite) {
+	batch := []struct{ Email, Name string }{
+		{s.email(), "Batch One"},
+		{s.email(), "Batch Two"},
+	}
+
+	if err := s.store.BatchCreate(s.ctx, batch); err != nil {
+		s.Fatalf("Failed to batch create users: %v", err)
+	}
+
+	for _, u := range batch {
+		user, err := s.store.GetByEmail(s.ctx, u.Email)
+		if err != nil {
+			s.Fatalf("Expected %s to exist: %v", u.Email, err)
+		}
+		s.cleanup(user.ID)
+	}
+
+	// A duplicate anywhere in the batch rolls back the whole batch
+	fresh := s.email()
+	err := s.store.BatchCreate(s.ctx, []struct{ Email, Name string }{
+		{fresh, "Fresh"},
+		{batch[0].Email, "Clash"},
+	})
+	s.expectKind(err, repository.ErrDuplicateEmail)
+
+	if user, err := s.store.GetByEmail(s.ctx, fresh); err == nil {
+		s.cleanup(user.ID)
+		s.Errorf("Expected %s to be rolled back", fresh)
+	}
+
+	err = s.store.BatchCreate(s.ctx, []struct{ Email, Name string }{{"invalid", "Invalid"}})
+	s.expectKind(err, repository.ErrInvalidInput)
+}
+
+func testTransferUserData(s *suite) {
+	from := s.create("Source Name")
+	to := s.create("Target Name")
+
+	// Read the target first so a caching store holds the old name
+	if _, err := s.store.GetByID(s.ctx, to.ID); err != nil {
+		s.Fatalf("Failed to get user: %v", err)
+	}
+
+	if err := s.store.TransferUserData(s.ctx, from.ID, to.ID); err != nil {
+		s.Fatalf("Failed to transfer user data: %v", err)
+	}
+
+	updated, err := s.store.GetByID(s.ctx, to.ID)
+	if err != nil {
+		s.Fatalf("Failed to get user: %v", err)
+	}
+
+	if updated.Name != "Source Name" {
+		s.Errorf("Expected name 'Source Name', got: %s", updated.Name)
+	}
+
+	err = s.store.TransferUserData(s.ctx, missingID, to.ID)
+	s.expectKind(err, repository.ErrNotFound)
+
+	err = s.store.TransferUserData(s.ctx, from.ID, missingID)
+	s.expectKind(err, repository.ErrNotFound)
+}
+
+func testConcurrency(s *suite) {
+	const workers = 10
+
+	s.Run("Distinct Creates", func(t *testing.T) {
+		var wg sync.WaitGroup
+		errs := make([]error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var user *models.User
+				user, errs[i] = s.store.Create(s.ctx, s.email(), fmt.Sprintf("Worker %d", i))
+				if errs[i] == nil {
+					s.cleanup(user.ID)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("Worker %d failed: %v", i, err)
+			}
+		}
+	})
+
+	s.Run("Same Email", func(t *testing.T) {
+		email := s.email()
+
+		var wg sync.WaitGroup
+		var created atomic.Int64
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user, err := s.store.Create(s.ctx, email, "Racer")
+				switch {
+				case err == nil:
+					created.Add(1)
+					s.cleanup(user.ID)
+				case !errors.Is(err, repository.ErrDuplicateEmail):
+					t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if created.Load() != 1 {
+			t.Errorf("Expected exactly one winner, got: %d", created.Load())
+		}
+	})
+
+	s.Run("Versioned Writers", func(t *testing.T) {
+		user := s.create("Versioned")
+
+		var wg sync.WaitGroup
+		var won atomic.Int64
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := s.store.UpdateIfVersion(s.ctx, user.ID, user.Version, user.Email, fmt.Sprintf("Writer %d", i))
+				switch {
+				case err == nil:
+					won.Add(1)
+				case !errors.Is(err, repository.ErrConflict):
+					t.Errorf("Expected ErrConflict, got: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if won.Load() != 1 {
+			t.Errorf("Expected exactly one successful write, got: %d", won.Load())
+		}
+
+		current, err := s.store.GetByID(s.ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if current.Version != user.Version+1 {
+			t.Errorf("Expected version %d, got: %d", user.Version+1, current.Version)
+		}
+	})
+}
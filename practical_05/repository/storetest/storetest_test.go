@@ -0,0 +1,34 @@
+package storetest_test
+
+import (
+	"context"
+	"database/sql"
+	"practical5-example/repository"
+	"practical5-example/repository/storetest"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.UserStore {
+		return repository.NewMemoryUserStore()
+	})
+}
+
+func TestSQLiteConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.UserStore {
+		db, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			t.Fatalf("Failed to open sqlite: %v", err)
+		}
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { db.Close() })
+
+		store, err := repository.NewSQLiteUserStore(context.Background(), db)
+		if err != nil {
+			t.Fatalf("Failed to create store: %v", err)
+		}
+		return store
+	})
+}
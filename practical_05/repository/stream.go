@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"practical5-example/models"
+	"sync/atomic"
+)
+
+// StreamOptions configures Stream
+type StreamOptions struct {
+	// BatchSize, when positive, reads through a server-side cursor
+	// (DECLARE/FETCH) inside a read-only transaction, holding at most
+	// BatchSize rows in the client at a time. Zero streams straight from
+	// a single query.
+	BatchSize int
+}
+
+// objectSeq keeps the names of cursors and staging tables unique
+var objectSeq atomic.Int64
+
+// errStopStream unwinds the cursor transaction when the consumer stops early
+var errStopStream = errors.New("stream stopped")
+
+// Stream yields every user matching filter, in filter.Sort order, without
+// collecting them into a slice. Use it instead of List or FindByNamePattern
+// for exports over the whole table:
+//
+//	for user, err := range repo.Stream(ctx, UserFilter{}, StreamOptions{BatchSize: 500}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Iteration ends after the first error, which is yielded with a zero User.
+// Cancelling ctx stops the stream with ctx.Err(). filter.WithTotal is ignored.
+func (r *UserRepository) Stream(ctx context.Context, filter UserFilter, opts StreamOptions) iter.Seq2[models.User, error] {
+	filter.IncludeDeleted = filter.IncludeDeleted || r.includeDeleted
+
+	return func(yield func(models.User, error) bool) {
+		q, err := filter.compile()
+		if err != nil {
+			yield(models.User{}, err)
+			return
+		}
+
+		if opts.BatchSize > 0 {
+			err = r.streamCursor(ctx, q, opts.BatchSize, yield)
+		} else {
+			err = streamRows(ctx, r.db, q, yield)
+		}
+
+		if err != nil && !errors.Is(err, errStopStream) {
+			yield(models.User{}, err)
+		}
+	}
+}
+
+// streamRows yields rows straight from a single query
+func streamRows(ctx context.Context, db DBExecutor, q *listQuery, yield func(models.User, error) bool) error {
+	rows, err := db.QueryContext(ctx, q.selectSQL(false), q.args...)
+	if err != nil {
+		return fmt.Errorf("failed to stream users: %w", classify(err))
+	}
+	defer rows.Close()
+
+	_, err = yieldRows(ctx, rows, yield)
+	return err
+}
+
+// streamCursor yields rows fetched in batches from a server-side cursor
+func (r *UserRepository) streamCursor(ctx context.Context, q *listQuery, batchSize int, yield func(models.User, error) bool) error {
+	name := fmt.Sprintf("user_stream_%d", objectSeq.Add(1))
+
+	return r.RunInTx(ctx, &sql.TxOptions{ReadOnly: true}, func(txRepo *UserRepository) error {
+		declare := fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", name, q.selectSQL(false))
+		if _, err := txRepo.db.ExecContext(ctx, declare, q.args...); err != nil {
+			return fmt.Errorf("failed to declare cursor: %w", classify(err))
+		}
+
+		fetch := fmt.Sprintf("FETCH FORWARD %d FROM %s", batchSize, name)
+		for {
+			rows, err := txRepo.db.QueryContext(ctx, fetch)
+			if err != nil {
+				return fmt.Errorf("failed to fetch users: %w", classify(err))
+			}
+
+			n, err := yieldRows(ctx, rows, yield)
+			rows.Close()
+			if err != nil {
+				return err
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		if _, err := txRepo.db.ExecContext(ctx, "CLOSE "+name); err != nil {
+			return fmt.Errorf("failed to close cursor: %w", classify(err))
+		}
+		return nil
+	})
+}
+
+// yieldRows passes each row to yield and reports how many rows it read.
+// It returns errStopStream if the consumer stopped early.
+func yieldRows(ctx context.Context, rows *sql.Rows, yield func(models.User, error) bool) (int, error) {
+	n := 0
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		var user models.User
+		if err := scanUser(rows, &user); err != nil {
+			return n, fmt.Errorf("failed to scan user: %w", err)
+		}
+		n++
+
+		if !yield(user, nil) {
+			return n, errStopStream
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("error iterating users: %w", classify(err))
+	}
+
+	return n, ctx.Err()
+}
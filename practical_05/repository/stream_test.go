@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
+
+	for i := 1; i <= 5; i++ {
+		f.Create(fixtures.User{Name: fmt.Sprintf("Stream %d", i)})
+	}
+
+	all, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+
+	for _, opts := range []StreamOptions{{}, {BatchSize: 2}} {
+		t.Run(fmt.Sprintf("Matches List BatchSize=%d", opts.BatchSize), func(t *testing.T) {
+			var streamed []int
+			for user, err := range repo.Stream(ctx, UserFilter{}, opts) {
+				if err != nil {
+					t.Fatalf("Stream failed: %v", err)
+				}
+				streamed = append(streamed, user.ID)
+			}
+
+			if len(streamed) != len(all) {
+				t.Fatalf("Expected %d users, got: %d", len(all), len(streamed))
+			}
+
+			for i, u := range all {
+				if streamed[i] != u.ID {
+					t.Errorf("Position %d: expected id %d, got: %d", i, u.ID, streamed[i])
+				}
+			}
+		})
+	}
+
+	t.Run("Filtered By Name", func(t *testing.T) {
+		count := 0
+		filter := UserFilter{NameLike: "stream%", Sort: SortByName}
+		for user, err := range repo.Stream(ctx, filter, StreamOptions{BatchSize: 2}) {
+			if err != nil {
+				t.Fatalf("Stream failed: %v", err)
+			}
+			count++
+			if want := fmt.Sprintf("Stream %d", count); user.Name != want {
+				t.Errorf("Expected %s, got: %s", want, user.Name)
+			}
+		}
+
+		if count != 5 {
+			t.Errorf("Expected 5 users, got: %d", count)
+		}
+	})
+
+	t.Run("Early Break Releases Cursor", func(t *testing.T) {
+		for _, opts := range []StreamOptions{{}, {BatchSize: 2}} {
+			seen := 0
+			for _, err := range repo.Stream(ctx, UserFilter{}, opts) {
+				if err != nil {
+					t.Fatalf("Stream failed: %v", err)
+				}
+				seen++
+				if seen == 3 {
+					break
+				}
+			}
+
+			if seen != 3 {
+				t.Errorf("Expected to stop after 3 users, got: %d", seen)
+			}
+		}
+
+		// The connection must be back in the pool and usable
+		if _, err := repo.CountUsers(ctx); err != nil {
+			t.Errorf("Expected repository to work after early break: %v", err)
+		}
+	})
+
+	t.Run("Cancelled Context", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+
+		var lastErr error
+		seen := 0
+		for _, err := range repo.Stream(cctx, UserFilter{}, StreamOptions{BatchSize: 1}) {
+			if err != nil {
+				lastErr = err
+				break
+			}
+			seen++
+			cancel()
+		}
+		cancel()
+
+		if !errors.Is(lastErr, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", lastErr)
+		}
+
+		if seen != 1 {
+			t.Errorf("Expected stream to stop after the cancelling row, got: %d rows", seen)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultNegativeTTL is how long new cached repositories remember that a
+// user does not exist. It is much shorter than the entry TTLs, since a
+// missing id may be created at any moment.
+const DefaultNegativeTTL = 30 * time.Second
+
+// tombstone is the value stored under a missing key. It lacks the envelope
+// magic every cache entry starts with, so it can never be decoded as a user
+// even if read from the wrong key.
+const tombstone = "-"
+
+// WithNegativeTTL sets how long not-found results are cached. Zero
+// disables negative caching. Call it before the repository is used.
+func (r *CachedUserRepository) WithNegativeTTL(ttl time.Duration) *CachedUserRepository {
+	r.negativeTTL = ttl
+	return r
+}
+
+// lookup reads the user entry for id and its tombstone in one round trip. It returns
+// the entry on a hit, or reports missing if a tombstone is cached.
+func (r *CachedUserRepository) lookup(ctx context.Context, id int) (entry *CacheEntry, missing bool) {
+	var values []any
+	err := r.guard(ctx, func() (err error) {
+		values, err = r.cache.MGet(ctx, r.keys.user(id), r.keys.missing(id)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, false
+	}
+
+	if data, ok := values[0].(string); ok {
+		if entry := r.decode(ctx, r.keys.user(id), []byte(data)); entry != nil {
+			return entry, false
+		}
+	}
+	return nil, values[1] == tombstone
+}
+
+// buryScript writes a tombstone unless the user's entry exists. A load
+// that missed may finish after a concurrent create has cached the new user
+// and cleared the tombstone; writing one then would hide that user.
+var buryScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+if redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[2], "NX") then
+	return 1
+end
+return 0
+`)
+
+// bury caches a tombstone for id if err says the user does not exist
+func (r *CachedUserRepository) bury(ctx context.Context, id int, err error) {
+	if r.negativeTTL > 0 && errors.Is(err, ErrNotFound) {
+		r.guard(ctx, func() error {
+			keys := []string{r.keys.user(id), r.keys.missing(id)}
+			return buryScript.Run(ctx, r.cache, keys, tombstone, r.negativeTTL.Milliseconds()).Err()
+		})
+	}
+}
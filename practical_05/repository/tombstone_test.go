@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"practical5-example/repository/fixtures"
+	"testing"
+	"time"
+)
+
+func TestCachedNegativeLookups(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	rdb := harness.Redis(t)
+	ctx := context.Background()
+	base := NewUserRepository(db)
+
+	t.Run("Missing User Is Remembered", func(t *testing.T) {
+		store := &slowStore{UserStore: base}
+		repo := NewCachedUserStore(store, rdb)
+
+		for i := 0; i < 3; i++ {
+			if _, err := repo.GetByIDCached(ctx, 424242); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Expected ErrNotFound, got: %v", err)
+			}
+		}
+
+		if calls := store.calls.Load(); calls != 1 {
+			t.Errorf("Expected 1 store query, got: %d", calls)
+		}
+		if stats := repo.Stats(); stats.NegativeHits != 2 {
+			t.Errorf("Expected 2 negative hits, got: %+v", stats)
+		}
+
+		ttl, _ := rdb.TTL(ctx, repo.keys.missing(424242)).Result()
+		if ttl <= 0 || ttl > DefaultNegativeTTL {
+			t.Errorf("Expected tombstone TTL within %v, got: %v", DefaultNegativeTTL, ttl)
+		}
+	})
+
+	t.Run("Create Clears Tombstone", func(t *testing.T) {
+		repo := NewCachedUserStore(base, rdb)
+		f := fixtures.New(t, base)
+
+		// Ids are sequential in this private database, so the next
+		// insert gets the id probed here
+		next := f.Create(fixtures.User{}).ID + 1
+		if _, err := repo.GetByIDCached(ctx, next); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got: %v", err)
+		}
+
+		created, err := repo.CreateCached(ctx, f.Email(), "Probed")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		defer repo.DeleteCached(ctx, created.ID)
+
+		if created.ID != next {
+			t.Fatalf("Expected id %d, got: %d", next, created.ID)
+		}
+
+		if _, err := repo.GetByIDCached(ctx, next); err != nil {
+			t.Errorf("Expected created user after tombstone, got: %v", err)
+		}
+	})
+
+	t.Run("Late Miss Keeps Created User", func(t *testing.T) {
+		repo := NewCachedUserStore(base, rdb)
+		user, err := repo.CreateCached(ctx, fixtures.New(t, base).Email(), "Created")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		// A load that missed before the create finishes after it
+		repo.bury(ctx, user.ID, ErrNotFound)
+
+		if exists, _ := rdb.Exists(ctx, repo.keys.missing(user.ID)).Result(); exists != 0 {
+			t.Error("Expected no tombstone beside a cached user")
+		}
+		rdb.Del(ctx, repo.keys.user(user.ID))
+		if _, err := repo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Errorf("Expected created user, got: %v", err)
+		}
+	})
+
+	t.Run("Restore Clears Tombstone", func(t *testing.T) {
+		repo := NewCachedUserStore(base, rdb)
+		user := fixtures.New(t, base).Create(fixtures.User{})
+
+		repo.SoftDeleteCached(ctx, user.ID)
+		repo.GetByIDCached(ctx, user.ID)
+
+		if err := repo.RestoreCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to restore user: %v", err)
+		}
+
+		if _, err := repo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Errorf("Expected restored user, got: %v", err)
+		}
+	})
+
+	t.Run("Tombstone Never Decodes As User", func(t *testing.T) {
+		repo := NewCachedUserStore(base, rdb)
+		user := fixtures.New(t, base).Create(fixtures.User{})
+
+		// Even a tombstone written under the user key reads as a miss
+		rdb.Set(ctx, repo.keys.user(user.ID), tombstone, time.Minute)
+
+		found, err := repo.GetByIDCached(ctx, user.ID)
+		if err != nil || found.Email != user.Email {
+			t.Errorf("Expected the stored user, got %+v (err=%v)", found, err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		store := &slowStore{UserStore: base}
+		repo := NewCachedUserStore(store, rdb).WithNegativeTTL(0)
+
+		repo.GetByIDCached(ctx, 434343)
+		repo.GetByIDCached(ctx, 434343)
+
+		if calls := store.calls.Load(); calls != 2 {
+			t.Errorf("Expected every lookup to query the store, got: %d", calls)
+		}
+	})
+}
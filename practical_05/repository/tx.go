@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Defaults used by RunInTxWithRetry when RetryOptions leaves a field zero
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = 10 * time.Millisecond
+	DefaultMaxDelay    = time.Second
+)
+
+// RetryOptions configures RunInTxWithRetry
+type RetryOptions struct {
+	// TxOptions sets the isolation level, e.g. sql.LevelSerializable
+	TxOptions *sql.TxOptions
+	// MaxAttempts is the retry budget for one call, counting the first try
+	MaxAttempts int
+	// BaseDelay and MaxDelay bound the exponential backoff between attempts
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// txBeginner is implemented by *sql.DB
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// RunInTx runs fn as a single unit of work. txRepo is bound to the
+// transaction and must not be used after fn returns or from other goroutines.
+//
+// The transaction is committed when fn returns nil and rolled back when it
+// returns an error or panics (the panic is re-raised after the rollback).
+// When r is already bound to a transaction, the call nests through a
+// SAVEPOINT so only fn's own writes are undone on failure; opts is ignored
+// in that case because the isolation level is fixed by the outer transaction.
+func (r *UserRepository) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(txRepo *UserRepository) error) error {
+	switch db := r.db.(type) {
+	case *sql.Tx:
+		return r.runInSavepoint(ctx, db, fn)
+	case txBeginner:
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", classify(err))
+		}
+		return runTx(tx, &UserRepository{db: tx, includeDeleted: r.includeDeleted, hooks: &commitHooks{}}, fn)
+	default:
+		return fmt.Errorf("transactions require *sql.DB or *sql.Tx, got %T", r.db)
+	}
+}
+
+// runTx commits or rolls back tx depending on how fn finishes
+func runTx(tx *sql.Tx, txRepo *UserRepository, fn func(txRepo *UserRepository) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(txRepo); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", classify(err))
+	}
+
+	txRepo.hooks.run()
+	return nil
+}
+
+// commitHooks are the functions registered with AfterCommit during one
+// transaction, in order
+type commitHooks struct {
+	fns []func()
+}
+
+func (h *commitHooks) run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
+
+// len and truncate are no-ops on the nil hooks of a repository that was
+// given a *sql.Tx directly
+func (h *commitHooks) len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fns)
+}
+
+func (h *commitHooks) truncate(n int) {
+	if h != nil {
+		h.fns = h.fns[:n]
+	}
+}
+
+// AfterCommit runs fn once the transaction r is bound to has committed,
+// after any hooks registered before it. If the transaction rolls back, or
+// the savepoint fn was registered under is rolled back to, fn never runs.
+// Outside a transaction there is nothing to wait for, so fn runs at once;
+// the same goes for a repository made over a *sql.Tx the caller commits
+// itself, since r cannot tell when that happens. Such a repository cannot
+// be cached for that reason.
+//
+// Hooks are for side effects that must not be seen before the data they
+// describe, such as cache invalidation. They run on the goroutine that
+// called RunInTx, after the transaction is finished.
+func (r *UserRepository) AfterCommit(fn func()) {
+	if r.hooks == nil {
+		fn()
+		return
+	}
+	r.hooks.fns = append(r.hooks.fns, fn)
+}
+
+// InTx reports whether r is bound to a transaction started by RunInTx, so
+// AfterCommit defers its functions
+func (r *UserRepository) InTx() bool {
+	return r.hooks != nil
+}
+
+// foreignTx reports whether r was made over a *sql.Tx rather than by
+// RunInTx, so nothing can be deferred until that transaction commits
+func (r *UserRepository) foreignTx() bool {
+	_, ok := r.db.(*sql.Tx)
+	return ok && r.hooks == nil
+}
+
+// runInSavepoint nests fn inside the transaction r is already bound to
+func (r *UserRepository) runInSavepoint(ctx context.Context, tx *sql.Tx, fn func(txRepo *UserRepository) error) (err error) {
+	txRepo := &UserRepository{db: tx, depth: r.depth + 1, includeDeleted: r.includeDeleted, hooks: r.hooks}
+	name := fmt.Sprintf("sp_%d", txRepo.depth)
+
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", classify(err))
+	}
+
+	// Hooks registered under the savepoint are dropped with its writes
+	registered := r.hooks.len()
+	rollback := func() error {
+		r.hooks.truncate(registered)
+		_, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(txRepo); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", classify(err))
+	}
+
+	return nil
+}
+
+// RunInTxWithRetry behaves like RunInTx but re-runs the whole transaction
+// when Postgres aborts it with a serialization failure (40001) or a deadlock
+// (40P01), sleeping with capped exponential backoff and full jitter between
+// attempts. fn may therefore run several times and must not have side
+// effects outside the transaction.
+//
+// It returns the number of attempts made. Inside an outer transaction a
+// failed attempt poisons the outer transaction too, so fn runs only once.
+func (r *UserRepository) RunInTxWithRetry(ctx context.Context, opts RetryOptions, fn func(txRepo *UserRepository) error) (int, error) {
+	if _, nested := r.db.(*sql.Tx); nested {
+		return 1, r.RunInTx(ctx, opts.TxOptions, fn)
+	}
+
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = DefaultBaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = DefaultMaxDelay
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.RunInTx(ctx, opts.TxOptions, fn)
+		if err == nil || !isRetryable(err) {
+			return attempt, err
+		}
+		if attempt >= opts.MaxAttempts {
+			return attempt, fmt.Errorf("transaction failed after %d attempts: %w", attempt, err)
+		}
+
+		timer := time.NewTimer(backoff(attempt, opts.BaseDelay, opts.MaxDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable reports whether err aborted a transaction that is safe to re-run
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// backoff returns a random delay in [0, min(max, base*2^(attempt-1))]
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	ceiling := base
+	for i := 1; i < attempt && ceiling < max; i++ {
+		ceiling *= 2
+	}
+	if ceiling > max {
+		ceiling = max
+	}
+	return rand.N(ceiling + 1)
+}
@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRunInTx(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	t.Run("Commit On Success", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		var created int
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			user, err := txRepo.Create(ctx, email, "Tx Commit")
+			if err != nil {
+				return err
+			}
+			created = user.ID
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to run transaction: %v", err)
+		}
+		defer repo.Delete(ctx, created)
+
+		if _, err := repo.GetByID(ctx, created); err != nil {
+			t.Errorf("Expected committed user to exist: %v", err)
+		}
+	})
+
+	t.Run("Rollback On Error", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		errBoom := errors.New("boom")
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			if _, err := txRepo.Create(ctx, email, "Tx Rollback"); err != nil {
+				return err
+			}
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("Expected fn error to be returned, got: %v", err)
+		}
+
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected user to be rolled back, got: %v", err)
+		}
+	})
+
+	t.Run("Rollback On Panic", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected panic to be re-raised")
+				}
+			}()
+
+			repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+				txRepo.Create(ctx, email, "Tx Panic")
+				panic("boom")
+			})
+		}()
+
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected user to be rolled back after panic, got: %v", err)
+		}
+	})
+
+	t.Run("Nested Savepoint Rollback", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		innerEmail := f.Email()
+		var outerID int
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			outer, err := txRepo.Create(ctx, f.Email(), "Tx Outer")
+			if err != nil {
+				return err
+			}
+			outerID = outer.ID
+
+			// The inner failure must only undo the inner insert
+			innerErr := txRepo.RunInTx(ctx, nil, func(innerRepo *UserRepository) error {
+				if _, err := innerRepo.Create(ctx, innerEmail, "Tx Inner"); err != nil {
+					return err
+				}
+				return errors.New("inner failure")
+			})
+			if innerErr == nil {
+				t.Error("Expected inner transaction to fail")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to run outer transaction: %v", err)
+		}
+		defer repo.Delete(ctx, outerID)
+
+		if _, err := repo.GetByID(ctx, outerID); err != nil {
+			t.Errorf("Expected outer user to be committed: %v", err)
+		}
+
+		if _, err := repo.GetByEmail(ctx, innerEmail); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected inner user to be rolled back, got: %v", err)
+		}
+	})
+
+	t.Run("BatchCreate Joins Outer Transaction", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		users := []struct{ Email, Name string }{
+			{f.Email(), "Tx Batch 1"},
+			{f.Email(), "Tx Batch 2"},
+		}
+		countBefore, _ := repo.CountUsers(ctx)
+
+		errAbort := errors.New("abort")
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			if err := txRepo.BatchCreate(ctx, users); err != nil {
+				return err
+			}
+
+			// Visible inside the transaction...
+			if _, err := txRepo.GetByEmail(ctx, users[0].Email); err != nil {
+				t.Errorf("Expected batch user inside transaction: %v", err)
+			}
+			return errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("Expected abort error, got: %v", err)
+		}
+
+		// ...but gone once the outer transaction rolls back
+		countAfter, _ := repo.CountUsers(ctx)
+		if countAfter != countBefore {
+			t.Errorf("Expected count %d after rollback, got: %d", countBefore, countAfter)
+		}
+	})
+
+	t.Run("Repository Built On Tx", func(t *testing.T) {
+		users := fixtures.New(t, repo).CreateN(2)
+		source, target := users[0], users[1]
+
+		// Rolled back before the fixtures are deleted, releasing the row locks
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		if err := NewUserRepository(tx).TransferUserData(ctx, source.ID, target.ID); err != nil {
+			t.Fatalf("Expected transfer on *sql.Tx to succeed, got: %v", err)
+		}
+	})
+}
+
+func TestAfterCommit(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	t.Run("Runs Once Committed", func(t *testing.T) {
+		ran := false
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			txRepo.AfterCommit(func() { ran = true })
+			if ran {
+				t.Error("Expected hook to wait for the commit")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to run transaction: %v", err)
+		}
+
+		if !ran {
+			t.Error("Expected hook to run after the commit")
+		}
+	})
+
+	t.Run("Dropped On Rollback", func(t *testing.T) {
+		ran := false
+		repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			txRepo.AfterCommit(func() { ran = true })
+			return errors.New("abort")
+		})
+
+		if ran {
+			t.Error("Expected hook not to run after a rollback")
+		}
+	})
+
+	t.Run("Savepoint Rollback Drops Its Hooks", func(t *testing.T) {
+		var ran []string
+		err := repo.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+			txRepo.AfterCommit(func() { ran = append(ran, "outer") })
+			txRepo.RunInTx(ctx, nil, func(innerRepo *UserRepository) error {
+				innerRepo.AfterCommit(func() { ran = append(ran, "rolled back") })
+				return errors.New("abort inner")
+			})
+			txRepo.RunInTx(ctx, nil, func(innerRepo *UserRepository) error {
+				innerRepo.AfterCommit(func() { ran = append(ran, "released") })
+				return nil
+			})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to run transaction: %v", err)
+		}
+
+		if strings.Join(ran, ",") != "outer,released" {
+			t.Errorf("Expected outer and released hooks in order, got: %v", ran)
+		}
+	})
+
+	t.Run("Runs Immediately Outside Transaction", func(t *testing.T) {
+		ran := false
+		repo.AfterCommit(func() { ran = true })
+
+		if !ran || repo.InTx() {
+			t.Error("Expected hook to run at once outside a transaction")
+		}
+	})
+}
+
+func TestRunInTxWithRetry(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	serializable := &sql.TxOptions{Isolation: sql.LevelSerializable}
+
+	t.Run("Concurrent Serializable Increments", func(t *testing.T) {
+		user := fixtures.New(t, repo).Create(fixtures.User{Name: "Counter 0"})
+
+		// Read-modify-write under SERIALIZABLE: conflicting attempts are
+		// aborted with 40001 and must be retried rather than lost
+		increment := func(txRepo *UserRepository) error {
+			current, err := txRepo.GetByID(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			n, err := strconv.Atoi(strings.TrimPrefix(current.Name, "Counter "))
+			if err != nil {
+				return err
+			}
+			return txRepo.Update(ctx, user.ID, current.Email, fmt.Sprintf("Counter %d", n+1))
+		}
+
+		opts := RetryOptions{TxOptions: serializable, MaxAttempts: 50, BaseDelay: time.Millisecond}
+		done := make(chan error, 2)
+
+		for g := 0; g < 2; g++ {
+			go func() {
+				for i := 0; i < 10; i++ {
+					attempts, err := repo.RunInTxWithRetry(ctx, opts, increment)
+					if err != nil {
+						done <- err
+						return
+					}
+					if attempts < 1 {
+						done <- fmt.Errorf("expected at least 1 attempt, got %d", attempts)
+						return
+					}
+				}
+				done <- nil
+			}()
+		}
+
+		for g := 0; g < 2; g++ {
+			if err := <-done; err != nil {
+				t.Fatalf("Increment failed: %v", err)
+			}
+		}
+
+		finalUser, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if finalUser.Name != "Counter 20" {
+			t.Errorf("Expected 'Counter 20', got: %s", finalUser.Name)
+		}
+	})
+
+	t.Run("Retry Budget Exhausted", func(t *testing.T) {
+		calls := 0
+		opts := RetryOptions{TxOptions: serializable, MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+		attempts, err := repo.RunInTxWithRetry(ctx, opts, func(txRepo *UserRepository) error {
+			calls++
+			return &pq.Error{Code: "40001"}
+		})
+		if err == nil {
+			t.Fatal("Expected error once the budget is spent")
+		}
+
+		if attempts != 3 || calls != 3 {
+			t.Errorf("Expected 3 attempts, got: attempts=%d calls=%d", attempts, calls)
+		}
+	})
+
+	t.Run("Non-Retryable Error", func(t *testing.T) {
+		errBoom := errors.New("boom")
+		attempts, err := repo.RunInTxWithRetry(ctx, RetryOptions{}, func(txRepo *UserRepository) error {
+			return errBoom
+		})
+		if !errors.Is(err, errBoom) {
+			t.Fatalf("Expected boom, got: %v", err)
+		}
+
+		if attempts != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", attempts)
+		}
+	})
+
+	t.Run("Backoff Is Capped", func(t *testing.T) {
+		for attempt := 1; attempt < 64; attempt++ {
+			if d := backoff(attempt, 10*time.Millisecond, time.Second); d < 0 || d > time.Second {
+				t.Fatalf("Attempt %d: delay %v out of range", attempt, d)
+			}
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"practical5-example/models"
+	"strings"
+)
+
+// MergePolicy decides how UpsertByEmail merges one field into a user
+// that already exists
+type MergePolicy int
+
+const (
+	// MergeOverwrite always stores the new value
+	MergeOverwrite MergePolicy = iota
+	// MergeKeepExisting leaves the stored value untouched
+	MergeKeepExisting
+	// MergeCoalesce stores the new value unless it is empty
+	MergeCoalesce
+)
+
+// UpsertPolicy holds the merge policy for each mutable field
+type UpsertPolicy struct {
+	Name MergePolicy
+}
+
+// mergeExpr returns the SET expression for column under policy
+func mergeExpr(column string, policy MergePolicy) (string, error) {
+	switch policy {
+	case MergeOverwrite:
+		return "EXCLUDED." + column, nil
+	case MergeKeepExisting:
+		return "users." + column, nil
+	case MergeCoalesce:
+		return fmt.Sprintf("CASE WHEN TRIM(EXCLUDED.%[1]s) = '' THEN users.%[1]s ELSE EXCLUDED.%[1]s END", column), nil
+	default:
+		return "", invalidInput(column+"_policy", policy, "unknown merge policy")
+	}
+}
+
+// UpsertByEmail creates the user, or merges name into the existing user
+// with the same email, in a single atomic statement. inserted reports
+// which of the two happened.
+//
+// An empty name is only accepted when the policy would keep the stored
+// name, and then only if the user already exists.
+func (r *UserRepository) UpsertByEmail(ctx context.Context, email, name string, policy UpsertPolicy) (*models.User, bool, error) {
+	nameExpr, hasName, err := prepareUpsert(email, name, policy)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if hasName {
+		return upsertUser(ctx, r.db, "(xmax = 0)", email, name, nameExpr)
+	}
+
+	// Without a name the row may only be merged, never created, so run in
+	// a transaction and undo an insert
+	var user *models.User
+	err = r.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		var inserted bool
+		user, inserted, err = upsertUser(ctx, txRepo.db, "(xmax = 0)", email, name, nameExpr)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			return invalidInput("name", name, "must not be empty for a new user")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, false, nil
+}
+
+// prepareUpsert validates an upsert and returns the SET expression for
+// name and whether a non-empty name was given
+func prepareUpsert(email, name string, policy UpsertPolicy) (string, bool, error) {
+	nameExpr, err := mergeExpr("name", policy.Name)
+	if err != nil {
+		return "", false, err
+	}
+
+	hasName := strings.TrimSpace(name) != ""
+	if hasName || policy.Name == MergeOverwrite {
+		err = validateUser(email, name)
+	} else {
+		err = validateEmail(email)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return nameExpr, hasName, nil
+}
+
+// upsertUser runs the upsert statement; insertedExpr is the SQL boolean
+// telling a fresh insert from an update
+func upsertUser(ctx context.Context, db DBExecutor, insertedExpr, email, name, nameExpr string) (*models.User, bool, error) {
+	query := fmt.Sprintf(`
+		INSERT INTO users (email, name)
+		VALUES ($1, $2)
+		ON CONFLICT (email) WHERE deleted_at IS NULL DO UPDATE SET name = %s, version = users.version + 1
+		RETURNING %s, %s AS inserted
+	`, nameExpr, userColumns, insertedExpr)
+
+	var user models.User
+	var deletedAt sql.NullTime
+	var inserted bool
+	err := db.QueryRowContext(ctx, query, email, name).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.CreatedAt,
+		&user.Version,
+		&deletedAt,
+		&inserted,
+	)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to upsert user: %w", classify(err))
+	}
+
+	return &user, inserted, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"practical5-example/repository/fixtures"
+	"testing"
+)
+
+func TestUpsertByEmail(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	t.Run("Insert Then Update", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		created, inserted, err := repo.UpsertByEmail(ctx, email, "First", UpsertPolicy{})
+		if err != nil {
+			t.Fatalf("Failed to upsert user: %v", err)
+		}
+		defer repo.Delete(ctx, created.ID)
+
+		if !inserted {
+			t.Error("Expected first upsert to insert")
+		}
+
+		updated, inserted, err := repo.UpsertByEmail(ctx, email, "Second", UpsertPolicy{})
+		if err != nil {
+			t.Fatalf("Failed to upsert user: %v", err)
+		}
+
+		if inserted {
+			t.Error("Expected second upsert to update")
+		}
+
+		if updated.ID != created.ID || updated.Name != "Second" {
+			t.Errorf("Expected id %d named Second, got: %+v", created.ID, updated)
+		}
+	})
+
+	t.Run("Merge Policies", func(t *testing.T) {
+		user := fixtures.New(t, repo).Create(fixtures.User{Name: "Stored"})
+
+		tests := []struct {
+			policy MergePolicy
+			name   string
+			want   string
+		}{
+			{MergeKeepExisting, "Ignored", "Stored"},
+			{MergeCoalesce, "", "Stored"},
+			{MergeCoalesce, "  ", "Stored"},
+			{MergeCoalesce, "Coalesced", "Coalesced"},
+			{MergeOverwrite, "Overwritten", "Overwritten"},
+		}
+
+		for _, tt := range tests {
+			got, inserted, err := repo.UpsertByEmail(ctx, user.Email, tt.name, UpsertPolicy{Name: tt.policy})
+			if err != nil {
+				t.Fatalf("Policy %d with %q: %v", tt.policy, tt.name, err)
+			}
+			if inserted || got.Name != tt.want {
+				t.Errorf("Policy %d with %q: expected %q, got: %q (inserted=%v)", tt.policy, tt.name, tt.want, got.Name, inserted)
+			}
+		}
+	})
+
+	t.Run("Empty Name Cannot Insert", func(t *testing.T) {
+		email := fixtures.New(t, repo).Email()
+		_, _, err := repo.UpsertByEmail(ctx, email, "", UpsertPolicy{Name: MergeCoalesce})
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Expected ErrInvalidInput, got: %v", err)
+		}
+
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected rejected insert to be rolled back, got: %v", err)
+		}
+	})
+
+	t.Run("Concurrent Upserts", func(t *testing.T) {
+		// The GetByEmail-then-Create pattern races here; the upsert must not
+		email := fixtures.New(t, repo).Email()
+		done := make(chan error, 2)
+		for g := 0; g < 2; g++ {
+			go func(g int) {
+				for i := 0; i < 10; i++ {
+					if _, _, err := repo.UpsertByEmail(ctx, email, fmt.Sprintf("Racer %d-%d", g, i), UpsertPolicy{}); err != nil {
+						done <- err
+						return
+					}
+				}
+				done <- nil
+			}(g)
+		}
+
+		for g := 0; g < 2; g++ {
+			if err := <-done; err != nil {
+				t.Fatalf("Concurrent upsert failed: %v", err)
+			}
+		}
+
+		user, err := repo.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("Expected exactly one user: %v", err)
+		}
+		repo.Delete(ctx, user.ID)
+	})
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"practical5-example/models"
@@ -11,90 +12,115 @@ type DBExecutor interface {
 	Query(query string, args ...interface{}) (*sql.Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
+// userColumns is the column list scanned by scanUser
+const userColumns = "id, email, name, created_at, version, deleted_at"
+
 type UserRepository struct {
 	db DBExecutor
+	// depth counts the savepoints opened on top of a *sql.Tx
+	depth int
+	// includeDeleted makes reads return soft-deleted users too
+	includeDeleted bool
+	// hooks collects AfterCommit functions; it is shared by every
+	// repository bound to the same transaction and nil outside one
+	hooks *commitHooks
 }
 
 func NewUserRepository(db DBExecutor) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// IncludeDeleted returns a copy of the repository whose reads also return
+// soft-deleted users
+func (r *UserRepository) IncludeDeleted() *UserRepository {
+	clone := *r
+	clone.includeDeleted = true
+	return &clone
+}
+
+// scope returns the condition that hides soft-deleted rows from reads
+func (r *UserRepository) scope() string {
+	if r.includeDeleted {
+		return "TRUE"
+	}
+	return "deleted_at IS NULL"
+}
+
 // GetByID retrieves a user by ID
-func (r *UserRepository) GetByID(id int) (*models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE id = $1"
+func (r *UserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
+	if id <= 0 {
+		return nil, invalidInput("id", id, "must be positive")
+	}
+
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1 AND " + r.scope()
 
 	var user models.User
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	err := scanUser(r.db.QueryRowContext(ctx, query, id), &user)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, notFound("id", id)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", classify(err))
 	}
 
 	return &user, nil
 }
 
 // GetByEmail retrieves a user by email
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE email = $1"
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE email = $1 AND " + r.scope()
 
 	var user models.User
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	err := scanUser(r.db.QueryRowContext(ctx, query, email), &user)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, notFound("email", email)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return nil, fmt.Errorf("failed to get user: %w", classify(err))
 	}
 
 	return &user, nil
 }
 
 // Create inserts a new user
-func (r *UserRepository) Create(email, name string) (*models.User, error) {
+func (r *UserRepository) Create(ctx context.Context, email, name string) (*models.User, error) {
+	if err := validateUser(email, name); err != nil {
+		return nil, err
+	}
+
 	query := `
 		INSERT INTO users (email, name)
 		VALUES ($1, $2)
-		RETURNING id, email, name, created_at
-	`
+		RETURNING ` + userColumns
 
 	var user models.User
-	err := r.db.QueryRow(query, email, name).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	err := scanUser(r.db.QueryRowContext(ctx, query, email, name), &user)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return nil, fmt.Errorf("failed to create user: %w", classify(err))
 	}
 
 	return &user, nil
 }
 
 // Update modifies an existing user
-func (r *UserRepository) Update(id int, email, name string) error {
-	query := "UPDATE users SET email = $1, name = $2 WHERE id = $3"
+func (r *UserRepository) Update(ctx context.Context, id int, email, name string) error {
+	if err := validateUser(email, name); err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET email = $1, name = $2, version = version + 1 WHERE id = $3 AND deleted_at IS NULL"
 
-	result, err := r.db.Exec(query, email, name, id)
+	result, err := r.db.ExecContext(ctx, query, email, name, id)
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return fmt.Errorf("failed to update user: %w", classify(err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -103,19 +129,60 @@ func (r *UserRepository) Update(id int, email, name string) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return notFound("id", id)
 	}
 
 	return nil
 }
 
-// Delete removes a user
-func (r *UserRepository) Delete(id int) error {
+// UpdateIfVersion modifies a user only if it is still at the given version,
+// returning the updated row with its new version. If the row changed since
+// it was read it fails with ErrConflict carrying the current version.
+func (r *UserRepository) UpdateIfVersion(ctx context.Context, id, version int, email, name string) (*models.User, error) {
+	if err := validateUser(email, name); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE users SET email = $1, name = $2, version = version + 1
+		WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+		RETURNING ` + userColumns
+
+	var user models.User
+	err := scanUser(r.db.QueryRowContext(ctx, query, email, name, id, version), &user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to update user: %w", classify(err))
+	}
+
+	// Nothing matched: either the user is gone or someone else wrote first
+	var current int
+	err = r.db.QueryRowContext(ctx, "SELECT version FROM users WHERE id = $1 AND deleted_at IS NULL", id).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil, notFound("id", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user version: %w", classify(err))
+	}
+
+	return nil, &Error{
+		Kind:  ErrConflict,
+		Field: "version",
+		Value: current,
+		Err:   fmt.Errorf("expected version %d", version),
+	}
+}
+
+// Delete permanently removes a user, soft-deleted or not. Prefer
+// SoftDelete unless the data really has to go.
+func (r *UserRepository) Delete(ctx context.Context, id int) error {
 	query := "DELETE FROM users WHERE id = $1"
 
-	result, err := r.db.Exec(query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return fmt.Errorf("failed to delete user: %w", classify(err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -124,181 +191,174 @@ func (r *UserRepository) Delete(id int) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return notFound("id", id)
 	}
 
 	return nil
 }
 
-// List retrieves all users
-func (r *UserRepository) List() ([]models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users ORDER BY id"
+// List retrieves all users. It loads the whole table, so prefer ListPage
+// for anything that can grow.
+func (r *UserRepository) List(ctx context.Context) ([]models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE " + r.scope() + " ORDER BY id"
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list users: %w", err)
+		return nil, fmt.Errorf("failed to list users: %w", classify(err))
 	}
 	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
-	}
-
-	return users, nil
+	return scanUsers(rows)
 }
 
 // FindByNamePattern finds users whose name matches a pattern
 // Uses ILIKE for case-insensitive pattern matching
-func (r *UserRepository) FindByNamePattern(pattern string) ([]models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE name ILIKE $1 ORDER BY name"
+func (r *UserRepository) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE name ILIKE $1 AND " + r.scope() + " ORDER BY name"
 
-	rows, err := r.db.Query(query, pattern)
+	rows, err := r.db.QueryContext(ctx, query, pattern)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find users by pattern: %w", err)
+		return nil, fmt.Errorf("failed to find users by pattern: %w", classify(err))
 	}
 	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
-	}
-
-	return users, nil
+	return scanUsers(rows)
 }
 
 // CountUsers returns total number of users
-func (r *UserRepository) CountUsers() (int, error) {
-	query := "SELECT COUNT(*) FROM users"
+func (r *UserRepository) CountUsers(ctx context.Context) (int, error) {
+	query := "SELECT COUNT(*) FROM users WHERE " + r.scope()
 
 	var count int
-	err := r.db.QueryRow(query).Scan(&count)
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
-		return 0, fmt.Errorf("failed to count users: %w", err)
+		return 0, fmt.Errorf("failed to count users: %w", classify(err))
 	}
 
 	return count, nil
 }
 
 // GetRecentUsers returns users created in the last N days
-func (r *UserRepository) GetRecentUsers(days int) ([]models.User, error) {
+func (r *UserRepository) GetRecentUsers(ctx context.Context, days int) ([]models.User, error) {
+	if days < 0 {
+		return nil, invalidInput("days", days, "must not be negative")
+	}
+
 	query := `
-		SELECT id, email, name, created_at
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE created_at >= NOW() - INTERVAL '1 day' * $1
+		WHERE created_at >= NOW() - INTERVAL '1 day' * $1 AND ` + r.scope() + `
 		ORDER BY created_at DESC
 	`
 
-	rows, err := r.db.Query(query, days)
+	rows, err := r.db.QueryContext(ctx, query, days)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get recent users: %w", err)
+		return nil, fmt.Errorf("failed to get recent users: %w", classify(err))
 	}
 	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
-	}
+	return scanUsers(rows)
+}
 
-	return users, nil
+// BatchCreate creates multiple users in a transaction.
+// When the repository is already bound to a transaction the inserts join it
+// through a savepoint instead of opening a new one.
+func (r *UserRepository) BatchCreate(ctx context.Context, users []struct{ Email, Name string }) error {
+	_, err := r.BatchCreateIDs(ctx, users)
+	return err
 }
 
-// BatchCreate creates multiple users in a transaction
-func (r *UserRepository) BatchCreate(users []struct{ Email, Name string }) error {
-	// This assumes r.db is actually *sql.DB for transaction support
-	db, ok := r.db.(*sql.DB)
-	if !ok {
-		return fmt.Errorf("batch operations require *sql.DB")
+// BatchCreateIDs behaves like BatchCreate and returns the new users' ids in
+// input order
+func (r *UserRepository) BatchCreateIDs(ctx context.Context, users []struct{ Email, Name string }) ([]int, error) {
+	for _, user := range users {
+		if err := validateUser(user.Email, user.Name); err != nil {
+			return nil, err
+		}
 	}
 
-	tx, err := db.Begin()
+	ids := make([]int, 0, len(users))
+	err := r.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		query := "INSERT INTO users (email, name) VALUES ($1, $2) RETURNING id"
+		for _, user := range users {
+			var id int
+			if err := txRepo.db.QueryRowContext(ctx, query, user.Email, user.Name).Scan(&id); err != nil {
+				return fmt.Errorf("failed to insert user: %w", classify(err))
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, err
 	}
+	return ids, nil
+}
 
-	defer func() {
+// TransferUserData copies the source user's name onto the target user
+// atomically, joining the surrounding transaction if there is one
+func (r *UserRepository) TransferUserData(ctx context.Context, fromID, toID int) error {
+	return r.RunInTx(ctx, nil, func(txRepo *UserRepository) error {
+		// Get source user
+		fromUser, err := txRepo.GetByID(ctx, fromID)
 		if err != nil {
-			tx.Rollback()
+			return fmt.Errorf("failed to get source user: %w", err)
 		}
-	}()
 
-	query := "INSERT INTO users (email, name) VALUES ($1, $2)"
-	for _, user := range users {
-		_, err = tx.Exec(query, user.Email, user.Name)
+		// Update target user with source user's name
+		result, err := txRepo.db.ExecContext(ctx, "UPDATE users SET name = $1, version = version + 1 WHERE id = $2 AND deleted_at IS NULL", fromUser.Name, toID)
 		if err != nil {
-			return fmt.Errorf("failed to insert user: %w", err)
+			return fmt.Errorf("failed to update target user: %w", classify(err))
 		}
-	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
 
-	return nil
+		if rowsAffected == 0 {
+			return notFound("id", toID)
+		}
+
+		return nil
+	})
 }
 
-// TransferUserData simulates a complex transaction
-func (r *UserRepository) TransferUserData(fromID, toID int) error {
-	db, ok := r.db.(*sql.DB)
-	if !ok {
-		return fmt.Errorf("transaction operations require *sql.DB")
-	}
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	tx, err := db.Begin()
+// scanUser reads a row selected with userColumns
+func scanUser(row rowScanner, user *models.User) error {
+	var deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt, &user.Version, &deletedAt)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return err
 	}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Get source user
-	var fromUser models.User
-	err = tx.QueryRow("SELECT id, email, name, created_at FROM users WHERE id = $1", fromID).
-		Scan(&fromUser.ID, &fromUser.Email, &fromUser.Name, &fromUser.CreatedAt)
-	if err != nil {
-		return fmt.Errorf("failed to get source user: %w", err)
+	user.DeletedAt = nil
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
 	}
+	return nil
+}
 
-	// Update target user with source user's name
-	_, err = tx.Exec("UPDATE users SET name = $1 WHERE id = $2", fromUser.Name, toID)
-	if err != nil {
-		return fmt.Errorf("failed to update target user: %w", err)
+// scanUsers reads every remaining row into a slice
+func scanUsers(rows *sql.Rows) ([]models.User, error) {
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err := scanUser(rows, &user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
 	}
 
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", classify(err))
 	}
 
-	return nil
+	return users, nil
 }
@@ -2,88 +2,39 @@ package repository
 
 import (
 	"context"
-	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"practical5-example/repository/fixtures"
 	"testing"
 	"time"
-
-	_ "github.com/lib/pq"
-	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/modules/postgres"
-	"github.com/testcontainers/testcontainers-go/wait"
 )
 
-var testDB *sql.DB
-
-func TestMain(m *testing.M) {
-	ctx := context.Background()
-
-	postgresContainer, err := postgres.RunContainer(ctx,
-		testcontainers.WithImage("postgres:15-alpine"),
-		postgres.WithDatabase("testdb"),
-		postgres.WithUsername("testuser"),
-		postgres.WithPassword("testpass"),
-		postgres.WithInitScripts("../migrations/init.sql"),
-		testcontainers.WithWaitStrategy(
-			wait.ForLog("database system is ready to accept connections").
-				WithOccurrence(2).
-				WithStartupTimeout(5*time.Second)),
-	)
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start container: %v\n", err)
-		os.Exit(1)
-	}
-
-	defer func() {
-		if err := postgresContainer.Terminate(ctx); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to terminate container: %v\n", err)
-		}
-	}()
-
-	connStr, err := postgresContainer.ConnectionString(ctx, "sslmode=disable")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get connection string: %v\n", err)
-		os.Exit(1)
-	}
-
-	testDB, err = sql.Open("postgres", connStr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
-		os.Exit(1)
-	}
-
-	if err = testDB.Ping(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to ping database: %v\n", err)
-		os.Exit(1)
-	}
-
-	code := m.Run()
-	testDB.Close()
-	os.Exit(code)
-}
-
 func TestGetByID(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	t.Run("User Exists", func(t *testing.T) {
-		user, err := repo.GetByID(1)
+		f := fixtures.New(t, repo)
+		created := f.Create(fixtures.User{})
+
+		user, err := repo.GetByID(ctx, created.ID)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if user.Email != "alice@example.com" {
-			t.Errorf("Expected email 'alice@example.com', got: %s", user.Email)
+		if user.Email != created.Email {
+			t.Errorf("Expected email '%s', got: %s", created.Email, user.Email)
 		}
 
-		if user.Name != "Alice Smith" {
-			t.Errorf("Expected name 'Alice Smith', got: %s", user.Name)
+		if user.Name != created.Name {
+			t.Errorf("Expected name '%s', got: %s", created.Name, user.Name)
 		}
 	})
 
 	t.Run("User Not Found", func(t *testing.T) {
-		_, err := repo.GetByID(9999)
+		_, err := repo.GetByID(ctx, missingID)
 		if err == nil {
 			t.Fatal("Expected error for non-existent user, got nil")
 		}
@@ -91,21 +42,28 @@ func TestGetByID(t *testing.T) {
 }
 
 func TestGetByEmail(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
 
 	t.Run("User Exists", func(t *testing.T) {
-		user, err := repo.GetByEmail("bob@example.com")
+		f := fixtures.New(t, repo)
+		created := f.Create(fixtures.User{})
+
+		user, err := repo.GetByEmail(ctx, created.Email)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if user.Name != "Bob Johnson" {
-			t.Errorf("Expected name 'Bob Johnson', got: %s", user.Name)
+		if user.ID != created.ID {
+			t.Errorf("Expected id %d, got: %d", created.ID, user.ID)
 		}
 	})
 
 	t.Run("User Not Found", func(t *testing.T) {
-		_, err := repo.GetByEmail("nonexistent@example.com")
+		_, err := repo.GetByEmail(ctx, f.Email())
 		if err == nil {
 			t.Fatal("Expected error for non-existent email, got nil")
 		}
@@ -113,10 +71,15 @@ func TestGetByEmail(t *testing.T) {
 }
 
 func TestCreate(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
 
 	t.Run("Create New User", func(t *testing.T) {
-		user, err := repo.Create("charlie@example.com", "Charlie Brown")
+		email := f.Email()
+		user, err := repo.Create(ctx, email, "Charlie Brown")
 		if err != nil {
 			t.Fatalf("Failed to create user: %v", err)
 		}
@@ -125,19 +88,22 @@ func TestCreate(t *testing.T) {
 			t.Error("Expected non-zero ID for created user")
 		}
 
-		if user.Email != "charlie@example.com" {
-			t.Errorf("Expected email 'charlie@example.com', got: %s", user.Email)
+		if user.Email != email {
+			t.Errorf("Expected email '%s', got: %s", email, user.Email)
 		}
 
 		if user.CreatedAt.IsZero() {
 			t.Error("Expected non-zero created_at timestamp")
 		}
 
-		defer repo.Delete(user.ID)
+		defer repo.Delete(ctx, user.ID)
 	})
 
 	t.Run("Create Duplicate Email", func(t *testing.T) {
-		_, err := repo.Create("alice@example.com", "Another Alice")
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{})
+
+		_, err := repo.Create(ctx, existing.Email, "Another User")
 		if err == nil {
 			t.Fatal("Expected error when creating user with duplicate email")
 		}
@@ -145,27 +111,29 @@ func TestCreate(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
 
 	t.Run("Update Existing User", func(t *testing.T) {
-		user, err := repo.Create("david@example.com", "David Davis")
-		if err != nil {
-			t.Fatalf("Failed to create test user: %v", err)
-		}
-		defer repo.Delete(user.ID)
+		f := fixtures.New(t, repo)
+		user := f.Create(fixtures.User{Name: "David Davis"})
+		email := f.Email()
 
-		err = repo.Update(user.ID, "david.updated@example.com", "David Updated")
+		err := repo.Update(ctx, user.ID, email, "David Updated")
 		if err != nil {
 			t.Fatalf("Failed to update user: %v", err)
 		}
 
-		updatedUser, err := repo.GetByID(user.ID)
+		updatedUser, err := repo.GetByID(ctx, user.ID)
 		if err != nil {
 			t.Fatalf("Failed to retrieve updated user: %v", err)
 		}
 
-		if updatedUser.Email != "david.updated@example.com" {
-			t.Errorf("Expected email 'david.updated@example.com', got: %s", updatedUser.Email)
+		if updatedUser.Email != email {
+			t.Errorf("Expected email '%s', got: %s", email, updatedUser.Email)
 		}
 
 		if updatedUser.Name != "David Updated" {
@@ -174,7 +142,7 @@ func TestUpdate(t *testing.T) {
 	})
 
 	t.Run("Update Non-Existent User", func(t *testing.T) {
-		err := repo.Update(9999, "nobody@example.com", "Nobody")
+		err := repo.Update(ctx, missingID, f.Email(), "Nobody")
 		if err == nil {
 			t.Fatal("Expected error when updating non-existent user")
 		}
@@ -182,27 +150,28 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	t.Run("Delete Existing User", func(t *testing.T) {
-		user, err := repo.Create("temp@example.com", "Temporary User")
-		if err != nil {
-			t.Fatalf("Failed to create test user: %v", err)
-		}
+		f := fixtures.New(t, repo)
+		user := f.Create(fixtures.User{Name: "Temporary User"})
 
-		err = repo.Delete(user.ID)
+		err := repo.Delete(ctx, user.ID)
 		if err != nil {
 			t.Fatalf("Failed to delete user: %v", err)
 		}
 
-		_, err = repo.GetByID(user.ID)
+		_, err = repo.GetByID(ctx, user.ID)
 		if err == nil {
 			t.Fatal("Expected error when retrieving deleted user")
 		}
 	})
 
 	t.Run("Delete Non-Existent User", func(t *testing.T) {
-		err := repo.Delete(9999)
+		err := repo.Delete(ctx, missingID)
 		if err == nil {
 			t.Fatal("Expected error when deleting non-existent user")
 		}
@@ -210,33 +179,41 @@ func TestDelete(t *testing.T) {
 }
 
 func TestList(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	created := fixtures.New(t, repo).CreateN(2)
 
-	users, err := repo.List()
+	users, err := repo.List(ctx)
 	if err != nil {
 		t.Fatalf("Failed to list users: %v", err)
 	}
 
 	if len(users) < 2 {
-		t.Errorf("Expected at least 2 users, got: %d", len(users))
+		t.Fatalf("Expected at least 2 users, got: %d", len(users))
 	}
 
-	if users[0].Email != "alice@example.com" {
-		t.Errorf("Expected first user email 'alice@example.com', got: %s", users[0].Email)
+	// List orders by id, so the users created last come last
+	last := users[len(users)-2:]
+	if last[0].Email != created[0].Email || last[1].Email != created[1].Email {
+		t.Errorf("Expected %s then %s last, got: %s then %s", created[0].Email, created[1].Email, last[0].Email, last[1].Email)
 	}
 }
 
 func TestFindByNamePattern(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	t.Run("Pattern Matches Multiple Users", func(t *testing.T) {
+		f := fixtures.New(t, repo)
 		// Create test users with similar patterns
-		user1, _ := repo.Create("smith1@example.com", "John Smith")
-		user2, _ := repo.Create("smith2@example.com", "Jane Smith")
-		defer repo.Delete(user1.ID)
-		defer repo.Delete(user2.ID)
+		f.Create(fixtures.User{Name: "John Smith"})
+		f.Create(fixtures.User{Name: "Jane Smith"})
 
-		users, err := repo.FindByNamePattern("%Smith%")
+		users, err := repo.FindByNamePattern(ctx, "%Smith%")
 		if err != nil {
 			t.Fatalf("Failed to find users by pattern: %v", err)
 		}
@@ -247,7 +224,7 @@ func TestFindByNamePattern(t *testing.T) {
 	})
 
 	t.Run("Pattern Matches No Users", func(t *testing.T) {
-		users, err := repo.FindByNamePattern("%XYZ%")
+		users, err := repo.FindByNamePattern(ctx, "%XYZ%")
 		if err != nil {
 			t.Fatalf("Failed to find users by pattern: %v", err)
 		}
@@ -258,7 +235,10 @@ func TestFindByNamePattern(t *testing.T) {
 	})
 
 	t.Run("Case Insensitive Pattern", func(t *testing.T) {
-		users, err := repo.FindByNamePattern("%alice%")
+		f := fixtures.New(t, repo)
+		f.Create(fixtures.User{Name: "Alice Smith"})
+
+		users, err := repo.FindByNamePattern(ctx, "%alice%")
 		if err != nil {
 			t.Fatalf("Failed to find users by pattern: %v", err)
 		}
@@ -270,21 +250,20 @@ func TestFindByNamePattern(t *testing.T) {
 }
 
 func TestCountUsers(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
-	initialCount, err := repo.CountUsers()
+	initialCount, err := repo.CountUsers(ctx)
 	if err != nil {
 		t.Fatalf("Failed to count users: %v", err)
 	}
 
 	// Create a new user
-	user, err := repo.Create("count@example.com", "Count User")
-	if err != nil {
-		t.Fatalf("Failed to create user: %v", err)
-	}
-	defer repo.Delete(user.ID)
+	fixtures.New(t, repo).Create(fixtures.User{Name: "Count User"})
 
-	newCount, err := repo.CountUsers()
+	newCount, err := repo.CountUsers(ctx)
 	if err != nil {
 		t.Fatalf("Failed to count users: %v", err)
 	}
@@ -295,18 +274,18 @@ func TestCountUsers(t *testing.T) {
 }
 
 func TestGetRecentUsers(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	t.Run("Recent Users Within Days", func(t *testing.T) {
+		f := fixtures.New(t, repo)
 		// Create a new user (will have current timestamp)
-		user, err := repo.Create("recent@example.com", "Recent User")
-		if err != nil {
-			t.Fatalf("Failed to create user: %v", err)
-		}
-		defer repo.Delete(user.ID)
+		user := f.Create(fixtures.User{Name: "Recent User"})
 
 		// Get users from last 7 days
-		users, err := repo.GetRecentUsers(7)
+		users, err := repo.GetRecentUsers(ctx, 7)
 		if err != nil {
 			t.Fatalf("Failed to get recent users: %v", err)
 		}
@@ -326,7 +305,7 @@ func TestGetRecentUsers(t *testing.T) {
 
 	t.Run("No Recent Users", func(t *testing.T) {
 		// Query for users in last 0 days (should return empty or very recent)
-		users, err := repo.GetRecentUsers(0)
+		users, err := repo.GetRecentUsers(ctx, 0)
 		if err != nil {
 			t.Fatalf("Failed to get recent users: %v", err)
 		}
@@ -337,51 +316,57 @@ func TestGetRecentUsers(t *testing.T) {
 }
 
 func TestBatchCreate(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
 
 	t.Run("Successful Batch Create", func(t *testing.T) {
 		users := []struct{ Email, Name string }{
-			{"batch1@example.com", "Batch User 1"},
-			{"batch2@example.com", "Batch User 2"},
-			{"batch3@example.com", "Batch User 3"},
+			{f.Email(), "Batch User 1"},
+			{f.Email(), "Batch User 2"},
+			{f.Email(), "Batch User 3"},
 		}
 
-		err := repo.BatchCreate(users)
+		err := repo.BatchCreate(ctx, users)
 		if err != nil {
 			t.Fatalf("Failed to batch create users: %v", err)
 		}
 
 		// Verify all users were created
 		for _, u := range users {
-			user, err := repo.GetByEmail(u.Email)
+			user, err := repo.GetByEmail(ctx, u.Email)
 			if err != nil {
 				t.Errorf("Failed to find user %s: %v", u.Email, err)
 			}
-			defer repo.Delete(user.ID)
+			defer repo.Delete(ctx, user.ID)
 		}
 	})
 
 	t.Run("Batch Create with Duplicate Email Rolls Back", func(t *testing.T) {
-		countBefore, _ := repo.CountUsers()
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{})
+		countBefore, _ := repo.CountUsers(ctx)
 
 		users := []struct{ Email, Name string }{
-			{"unique1@example.com", "Unique 1"},
-			{"alice@example.com", "Duplicate Alice"}, // Duplicate!
-			{"unique2@example.com", "Unique 2"},
+			{f.Email(), "Unique 1"},
+			{existing.Email, "Duplicate"}, // Duplicate!
+			{f.Email(), "Unique 2"},
 		}
 
-		err := repo.BatchCreate(users)
+		err := repo.BatchCreate(ctx, users)
 		if err == nil {
 			t.Fatal("Expected error for duplicate email in batch")
 		}
 
-		countAfter, _ := repo.CountUsers()
+		countAfter, _ := repo.CountUsers(ctx)
 		if countAfter != countBefore {
 			t.Error("Expected transaction rollback, but count changed")
 		}
 
 		// Verify none of the unique users were created
-		_, err = repo.GetByEmail("unique1@example.com")
+		_, err = repo.GetByEmail(ctx, users[0].Email)
 		if err == nil {
 			t.Error("Expected unique1 to not exist after rollback")
 		}
@@ -389,16 +374,20 @@ func TestBatchCreate(t *testing.T) {
 }
 
 func TestTransactionRollback(t *testing.T) {
-	countBefore, _ := NewUserRepository(testDB).CountUsers()
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	countBefore, _ := NewUserRepository(db).CountUsers(ctx)
+	email := fixtures.New(t, NewUserRepository(db)).Email()
 
-	tx, err := testDB.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	// Create user in transaction
-	_, err = tx.Exec("INSERT INTO users (email, name) VALUES ($1, $2)",
-		"tx@example.com", "TX User")
+	_, err = tx.ExecContext(ctx, "INSERT INTO users (email, name) VALUES ($1, $2)",
+		email, "TX User")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -407,47 +396,49 @@ func TestTransactionRollback(t *testing.T) {
 	tx.Rollback()
 
 	// Verify count is unchanged
-	repo := NewUserRepository(testDB)
-	countAfter, _ := repo.CountUsers()
+	repo := NewUserRepository(db)
+	countAfter, _ := repo.CountUsers(ctx)
 	if countAfter != countBefore {
 		t.Error("Transaction was not rolled back properly")
 	}
 
 	// Verify user doesn't exist
-	_, err = repo.GetByEmail("tx@example.com")
+	_, err = repo.GetByEmail(ctx, email)
 	if err == nil {
 		t.Error("Expected user to not exist after rollback")
 	}
 }
 
 func TestTransferUserData(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	t.Run("Successful Transfer", func(t *testing.T) {
+		f := fixtures.New(t, repo)
 		// Create source and target users
-		source, _ := repo.Create("source@example.com", "Source User")
-		target, _ := repo.Create("target@example.com", "Target User")
-		defer repo.Delete(source.ID)
-		defer repo.Delete(target.ID)
+		source := f.Create(fixtures.User{Name: "Source User"})
+		target := f.Create(fixtures.User{Name: "Target User"})
 
 		// Transfer data
-		err := repo.TransferUserData(source.ID, target.ID)
+		err := repo.TransferUserData(ctx, source.ID, target.ID)
 		if err != nil {
 			t.Fatalf("Failed to transfer data: %v", err)
 		}
 
 		// Verify target has source's name
-		targetUser, _ := repo.GetByID(target.ID)
+		targetUser, _ := repo.GetByID(ctx, target.ID)
 		if targetUser.Name != "Source User" {
 			t.Errorf("Expected target name 'Source User', got: %s", targetUser.Name)
 		}
 	})
 
 	t.Run("Transfer with Invalid Source ID", func(t *testing.T) {
-		target, _ := repo.Create("target2@example.com", "Target 2")
-		defer repo.Delete(target.ID)
+		f := fixtures.New(t, repo)
+		target := f.Create(fixtures.User{})
 
-		err := repo.TransferUserData(9999, target.ID)
+		err := repo.TransferUserData(ctx, missingID, target.ID)
 		if err == nil {
 			t.Fatal("Expected error for invalid source ID")
 		}
@@ -455,25 +446,27 @@ func TestTransferUserData(t *testing.T) {
 }
 
 func TestConcurrentWrites(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
 
 	// Create a user
-	user, _ := repo.Create("concurrent@example.com", "Concurrent User")
-	defer repo.Delete(user.ID)
+	user := fixtures.New(t, repo).Create(fixtures.User{Name: "Concurrent User"})
 
 	// Simulate concurrent updates
 	done := make(chan bool, 2)
 
 	go func() {
 		for i := 0; i < 10; i++ {
-			repo.Update(user.ID, "concurrent@example.com", fmt.Sprintf("Name %d", i))
+			repo.Update(ctx, user.ID, user.Email, fmt.Sprintf("Name %d", i))
 		}
 		done <- true
 	}()
 
 	go func() {
 		for i := 0; i < 10; i++ {
-			repo.Update(user.ID, "concurrent@example.com", fmt.Sprintf("Other %d", i))
+			repo.Update(ctx, user.ID, user.Email, fmt.Sprintf("Other %d", i))
 		}
 		done <- true
 	}()
@@ -482,12 +475,211 @@ func TestConcurrentWrites(t *testing.T) {
 	<-done
 
 	// Verify user still exists and is valid
-	finalUser, err := repo.GetByID(user.ID)
+	finalUser, err := repo.GetByID(ctx, user.ID)
 	if err != nil {
 		t.Fatalf("User corrupted after concurrent writes: %v", err)
 	}
 
-	if finalUser.Email != "concurrent@example.com" {
+	if finalUser.Email != user.Email {
 		t.Error("User email changed unexpectedly")
 	}
 }
+
+func TestContextCancellation(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	repo := NewUserRepository(db)
+
+	t.Run("Cancelled Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := repo.GetByID(ctx, missingID)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected context.Canceled, got: %v", err)
+		}
+	})
+
+	t.Run("Deadline Exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// pg_sleep outlives the deadline, so the driver must abort the query
+		_, err := db.ExecContext(ctx, "SELECT pg_sleep(1)")
+		if err == nil {
+			t.Fatal("Expected slow query to be cancelled")
+		}
+
+		_, err = repo.List(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+		}
+	})
+}
+
+func TestErrorTaxonomy(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
+
+	t.Run("Not Found Carries ID", func(t *testing.T) {
+		_, err := repo.GetByID(ctx, missingID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got: %v", err)
+		}
+
+		var repoErr *Error
+		if !errors.As(err, &repoErr) || repoErr.Field != "id" || repoErr.Value != missingID {
+			t.Errorf("Expected error to carry id=%d, got: %+v", missingID, repoErr)
+		}
+	})
+
+	t.Run("Not Found On Update And Delete", func(t *testing.T) {
+		if err := repo.Update(ctx, missingID, f.Email(), "Nobody"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound from Update, got: %v", err)
+		}
+
+		if err := repo.Delete(ctx, missingID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound from Delete, got: %v", err)
+		}
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		f := fixtures.New(t, repo)
+		existing := f.Create(fixtures.User{})
+
+		_, err := repo.Create(ctx, existing.Email, "Another User")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+
+		var repoErr *Error
+		if !errors.As(err, &repoErr) || repoErr.Value != existing.Email {
+			t.Errorf("Expected error to carry the duplicate email, got: %+v", repoErr)
+		}
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		_, err := repo.Create(ctx, "", "No Email")
+		if !errors.Is(err, ErrInvalidInput) {
+			t.Fatalf("Expected ErrInvalidInput, got: %v", err)
+		}
+
+		var repoErr *Error
+		if !errors.As(err, &repoErr) || repoErr.Field != "email" {
+			t.Errorf("Expected error to name the email field, got: %+v", repoErr)
+		}
+	})
+}
+
+func TestUpdateIfVersion(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+	f := fixtures.New(t, repo)
+
+	user := f.Create(fixtures.User{Name: "Versioned User"})
+
+	if user.Version != 1 {
+		t.Fatalf("Expected new user at version 1, got: %d", user.Version)
+	}
+
+	t.Run("Matching Version", func(t *testing.T) {
+		updated, err := repo.UpdateIfVersion(ctx, user.ID, 1, user.Email, "Version Two")
+		if err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		if updated.Version != 2 || updated.Name != "Version Two" {
+			t.Errorf("Expected version 2 named 'Version Two', got: %+v", updated)
+		}
+	})
+
+	t.Run("Stale Version", func(t *testing.T) {
+		_, err := repo.UpdateIfVersion(ctx, user.ID, 1, user.Email, "Stale Write")
+		if !errors.Is(err, ErrConflict) {
+			t.Fatalf("Expected ErrConflict, got: %v", err)
+		}
+
+		var repoErr *Error
+		if !errors.As(err, &repoErr) || repoErr.Value != 2 {
+			t.Errorf("Expected conflict to carry current version 2, got: %+v", repoErr)
+		}
+
+		current, _ := repo.GetByID(ctx, user.ID)
+		if current.Name != "Version Two" {
+			t.Errorf("Expected stale write to be rejected, got: %s", current.Name)
+		}
+	})
+
+	t.Run("Plain Update Bumps Version", func(t *testing.T) {
+		if err := repo.Update(ctx, user.ID, user.Email, "Version Three"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		current, _ := repo.GetByID(ctx, user.ID)
+		if current.Version != 3 {
+			t.Errorf("Expected version 3, got: %d", current.Version)
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		_, err := repo.UpdateIfVersion(ctx, missingID, 1, f.Email(), "Nobody")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got: %v", err)
+		}
+	})
+}
+
+func TestConcurrentVersionedWrites(t *testing.T) {
+	t.Parallel()
+	db := harness.DB(t)
+	ctx := context.Background()
+	repo := NewUserRepository(db)
+
+	// Create a user
+	user := fixtures.New(t, repo).Create(fixtures.User{Name: "OCC User"})
+
+	// Each writer re-reads and retries on conflict, so no update is lost
+	write := func(prefix string) error {
+		for i := 0; i < 10; i++ {
+			for {
+				current, err := repo.GetByID(ctx, user.ID)
+				if err != nil {
+					return err
+				}
+				_, err = repo.UpdateIfVersion(ctx, user.ID, current.Version, current.Email, fmt.Sprintf("%s %d", prefix, i))
+				if errors.Is(err, ErrConflict) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+		return nil
+	}
+
+	done := make(chan error, 2)
+	go func() { done <- write("Name") }()
+	go func() { done <- write("Other") }()
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("Writer failed: %v", err)
+		}
+	}
+
+	finalUser, err := repo.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	if finalUser.Version != 21 {
+		t.Errorf("Expected version 21 after 20 successful writes, got: %d", finalUser.Version)
+	}
+}